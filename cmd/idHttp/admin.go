@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/luw2007/rabbitid/cmd/idHttp/service"
+	"github.com/luw2007/rabbitid/store"
+)
+
+// AdminResponse 管理接口的返回，Names 为db或者table列表，ID 为计数
+type AdminResponse struct {
+	Code  int64    `json:"code,omitempty"`
+	ID    int64    `json:"id,omitempty"`
+	Names []string `json:"names,omitempty"`
+	Msg   string   `json:"msg,omitempty"`
+}
+
+// adminAuth 管理接口需要在请求头中携带配置的token：Authorization: Bearer {token}
+func adminAuth(token string) gin.HandlerFunc {
+	want := []byte("Bearer " + token)
+	return func(c *gin.Context) {
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), want) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, AdminResponse{Code: -1, Msg: "unauthorized"})
+		}
+	}
+}
+
+// adminRoutes 注册管理接口，app 对应存储的db，db 对应存储的table，和发号接口一致。
+// 所有接口都需要token，newStore 创建切换的目标存储
+func adminRoutes(g *gin.Engine, svc service.Service, dataCenter uint8, token string, newStore func(storeType, uri string) (store.Store, error)) {
+	group := g.Group("/admin", adminAuth(token))
+	group.POST("/migrate", func(c *gin.Context) {
+		storeType := c.PostForm("store")
+		uri := c.PostForm("uri")
+		margin, err := strconv.ParseInt(c.DefaultPostForm("margin", "0"), 10, 64)
+		if (storeType == "" && uri == "") || err != nil || margin < 0 {
+			c.JSON(200, AdminResponse{Code: -1, Msg: "argument error"})
+			return
+		}
+		if svc.Migration().State == service.MigrationRunning {
+			c.JSON(200, AdminResponse{Code: -1, Msg: service.ErrMigrationRunning.Error()})
+			return
+		}
+		to, err := newStore(storeType, uri)
+		if err != nil {
+			c.JSON(200, AdminResponse{Code: -1, Msg: err.Error()})
+			return
+		}
+		// 切换的时间和发号器数量有关，后台执行，通过GET /admin/migrate 查看进度
+		go svc.Migrate(context.Background(), to, margin)
+		c.JSON(200, AdminResponse{})
+	})
+	group.GET("/migrate", func(c *gin.Context) {
+		c.JSON(200, svc.Migration())
+	})
+	// 审批table，table_mode 为 approval 时未知的table需要审批后才能发号
+	group.POST("/approve", func(c *gin.Context) {
+		app, db := c.PostForm("app"), c.PostForm("db")
+		if app == "" || db == "" {
+			c.JSON(200, AdminResponse{Code: -1, Msg: "argument error"})
+			return
+		}
+		if err := svc.Approve(c, app, db); err != nil {
+			c.JSON(200, AdminResponse{Code: -1, Msg: err.Error()})
+			return
+		}
+		c.JSON(200, AdminResponse{})
+	})
+	group.GET("/pending", func(c *gin.Context) {
+		c.JSON(200, svc.Pending())
+	})
+
+	// 切换存储之后使用新的存储
+	group.Use(func(c *gin.Context) {
+		admin, err := store.AdminOf(svc.Store())
+		if err != nil {
+			c.AbortWithStatusJSON(200, AdminResponse{Code: -1, Msg: err.Error()})
+			return
+		}
+		c.Set("admin", admin)
+	})
+	adminOf := func(c *gin.Context) store.Admin { return c.MustGet("admin").(store.Admin) }
+	reply := func(c *gin.Context, resp AdminResponse, err error) {
+		if err != nil {
+			c.JSON(200, AdminResponse{Code: -1, Msg: err.Error()})
+			return
+		}
+		c.JSON(200, resp)
+	}
+
+	group.POST("/db", func(c *gin.Context) {
+		app := c.PostForm("app")
+		if app == "" {
+			c.JSON(200, AdminResponse{Code: -1, Msg: "argument error"})
+			return
+		}
+		reply(c, AdminResponse{}, adminOf(c).CreateDB(c, dataCenter, app))
+	})
+	group.GET("/db", func(c *gin.Context) {
+		dbs, err := adminOf(c).ListDBs(c, dataCenter)
+		reply(c, AdminResponse{Names: dbs}, err)
+	})
+	group.GET("/table", func(c *gin.Context) {
+		app := c.Query("app")
+		if app == "" {
+			c.JSON(200, AdminResponse{Code: -1, Msg: "argument error"})
+			return
+		}
+		tables, err := adminOf(c).ListTables(c, dataCenter, app)
+		reply(c, AdminResponse{Names: tables}, err)
+	})
+	group.GET("/counter", func(c *gin.Context) {
+		app := c.Query("app")
+		db := c.Query("db")
+		if app == "" || db == "" {
+			c.JSON(200, AdminResponse{Code: -1, Msg: "argument error"})
+			return
+		}
+		max, err := adminOf(c).GetCounter(c, dataCenter, app, db)
+		reply(c, AdminResponse{ID: max}, err)
+	})
+	group.DELETE("/table", func(c *gin.Context) {
+		app := c.Query("app")
+		db := c.Query("db")
+		if app == "" || db == "" {
+			c.JSON(200, AdminResponse{Code: -1, Msg: "argument error"})
+			return
+		}
+		// 发号器还在使用时拒绝删除
+		reply(c, AdminResponse{}, svc.DeleteTable(c, app, db))
+	})
+}
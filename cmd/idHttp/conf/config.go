@@ -16,6 +16,10 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rifflock/lfshook"
 	"github.com/sirupsen/logrus"
+
+	"github.com/luw2007/rabbitid/cmd/idHttp/service"
+	"github.com/luw2007/rabbitid/generator"
+	"github.com/luw2007/rabbitid/store"
 )
 
 const (
@@ -24,16 +28,41 @@ const (
 	defaultRedisAddress = "127.0.0.1:6379"
 	defaultEtcdAddress  = "127.0.0.1:2379"
 	defaultZKAddress    = "127.0.0.1:2181"
+	defaultMySQLDSN     = "root@tcp(127.0.0.1:3306)/rabbitid"
+	defaultPostgresDSN  = "postgres://127.0.0.1:5432/rabbitid?sslmode=disable"
+	defaultSQLiteDSN    = "rabbitid.db?_busy_timeout=5000"
+	defaultFilePath     = "rabbitid.bolt"
 	defaultStep         = 1000
+	defaultTableMode    = "open"
+	// defaultIdleSecond 10分钟没有使用的table不再预加载，defaultEvictSecond 1天没有使用的发号器被淘汰
+	defaultIdleSecond  = 600
+	defaultEvictSecond = 86400
 
 	defaultStoreMinSecond = 300
 	defaultStoreMaxSecond = 1800
+
+	defaultBreakerCooldownSecond = 1
+	defaultRetryBaseMillisecond  = 10
+	defaultRetryMaxMillisecond   = 100
 )
 
+// defaultStoreURIs 各个存储类型默认的连接串
+var defaultStoreURIs = map[string]string{
+	"redis":    defaultRedisAddress,
+	"etcd":     defaultEtcdAddress,
+	"zk":       defaultZKAddress,
+	"file":     defaultFilePath,
+	"mysql":    defaultMySQLDSN,
+	"postgres": defaultPostgresDSN,
+	"sqlite":   defaultSQLiteDSN,
+}
+
 type Config struct {
 	Server struct {
 		Address string `toml:"addr"`
 		Debug   bool   `toml:"debug"`
+		// AdminToken 管理接口的token，为空时不开启/admin 和 ADMIN
+		AdminToken string `toml:"admin_token"`
 	} `toml:"server"`
 	Log struct {
 		Level string `toml:"level"`
@@ -44,13 +73,70 @@ type Config struct {
 		URI       string `toml:"uri"`
 		MinSecond int    `toml:"min_second"`
 		MaxSecond int    `toml:"max_second"`
+		// Watermark 本地记录已分配最大值的文件，为空不检查存储计数回退
+		Watermark string `toml:"watermark"`
 		Min       time.Duration
 		Max       time.Duration
+		// ZK zk存储的认证和会话配置
+		ZK struct {
+			User          string `toml:"user"`
+			Password      string `toml:"password"`
+			SessionSecond int    `toml:"session_second"`
+			ConnectSecond int    `toml:"connect_second"`
+			// UpgradeStep 升级Range返回值时计数增加的数量，至少为旧版本的最大加载数量，默认store.DefaultUpgradeStep
+			UpgradeStep int64 `toml:"upgrade_step"`
+		} `toml:"zk"`
+		// Etcd etcd存储的证书、认证、超时和key前缀配置
+		Etcd struct {
+			CertFile           string `toml:"cert_file"`
+			KeyFile            string `toml:"key_file"`
+			CAFile             string `toml:"ca_file"`
+			User               string `toml:"user"`
+			Password           string `toml:"password"`
+			DialSecond         int    `toml:"dial_second"`
+			RequestMillisecond int    `toml:"request_millisecond"`
+			Root               string `toml:"root"`
+		} `toml:"etcd"`
+		// Middleware 存储的熔断、重试和超时，failures 或者 attempts 为0时不使用
+		Middleware struct {
+			BreakerFailures       int `toml:"breaker_failures"`
+			BreakerCooldownSecond int `toml:"breaker_cooldown_second"`
+			RetryAttempts         int `toml:"retry_attempts"`
+			RetryBaseMillisecond  int `toml:"retry_base_millisecond"`
+			RetryMaxMillisecond   int `toml:"retry_max_millisecond"`
+			TimeoutMillisecond    int `toml:"timeout_millisecond"`
+		} `toml:"middleware"`
 	} `toml:"store"`
 	Generate struct {
 		DataCenter uint8 `toml:"dataCenter"`
 		Step       int64 `toml:"step"`
+		// Worker 时间戳发号的进程ID，同一个机房的进程需要不同
+		Worker uint16 `toml:"worker"`
+		// TableMode table的准入模式：open 任意table，strict 只允许声明或者已经存在的table，approval 未知的table需要审批
+		TableMode string `toml:"table_mode"`
+		// IdleSecond 没有使用的table不再预加载，EvictSecond 没有使用的发号器被淘汰，MaxGenerators 发号器数量上限，
+		// 负数表示不限制
+		IdleSecond    int `toml:"idle_second"`
+		EvictSecond   int `toml:"evict_second"`
+		MaxGenerators int `toml:"max_generators"`
+		// Layout ID的位分布，PreviousLayout 之前发号使用的位分布，没有配置的字段使用generator.DefaultLayout
+		Layout         generator.IDLayout `toml:"layout"`
+		PreviousLayout generator.IDLayout `toml:"previous_layout"`
 	} `toml:"generate"`
+	// Tables 单个table的配置，db 和 table 支持通配符，按顺序使用第一个匹配的配置
+	Tables []struct {
+		DB                string  `toml:"db"`
+		Table             string  `toml:"table"`
+		Step              int64   `toml:"step"`
+		MinSecond         int     `toml:"min_second"`
+		MaxSecond         int     `toml:"max_second"`
+		MaxStepMultiplier int64   `toml:"max_step_multiplier"`
+		Kind              string  `toml:"kind"`
+		MaxID             int64   `toml:"max_id"`
+		RingSize          int     `toml:"ring_size"`
+		ExpandSize        int     `toml:"expand_size"`
+		RefillRatio       float64 `toml:"refill_ratio"`
+	} `toml:"table"`
 	Logger *logrus.Logger `toml:"-"`
 }
 
@@ -66,6 +152,9 @@ func Init() Config {
 	file := filepath.Join(APPPath, "etc", ConfFile)
 
 	var config Config
+	// 位分布先使用默认值，toml只覆盖配置了的字段，部分配置不会把其他字段置为0
+	config.Generate.Layout = generator.DefaultLayout
+	config.Generate.PreviousLayout = generator.DefaultLayout
 	flag.Parse()
 	if _, err := toml.DecodeFile(file, &config); err != nil {
 		log.Fatalln("decode toml err", err.Error())
@@ -78,39 +167,60 @@ func Init() Config {
 		httpAddr   = flag.String("http.addr", envString("ADDRESS", config.Server.Address), "HTTP listen address")
 		dataCenter = flag.Uint64("dataCenter", envUint64("DATA_CENTER", uint64(config.Generate.DataCenter)), "DataCenter ID: {M5: 0, LG: 1, SJQ: 2}")
 		step       = flag.Int64("step", envInt64("DATA_CENTER", config.Generate.Step), "Step")
-		storeType  = flag.String("store", envString("STORE", config.Store.Type), "Store type：redis etcd zk")
+		storeType  = flag.String("store", envString("STORE", config.Store.Type), "Store type：redis etcd zk memory file mysql postgres sqlite replicated, empty for store.uri scheme")
 		storeURI   = flag.String("store.uri", envString("URI", config.Store.URI), "Store URI")
+		watermark  = flag.String("store.watermark", envString("WATERMARK", config.Store.Watermark), "Store watermark file")
+		worker     = flag.Uint64("worker", envUint64("WORKER", uint64(config.Generate.Worker)), "Snowflake worker ID")
+		tableMode  = flag.String("table.mode", envString("TABLE_MODE", config.Generate.TableMode), "Table mode: open strict approval")
+		adminToken = flag.String("admin.token", envString("ADMIN_TOKEN", config.Server.AdminToken), "Admin token, empty to disable admin")
 	)
 	flag.Parse()
 
 	config.Server.Address = *httpAddr
+	config.Server.AdminToken = *adminToken
 	config.Store.Type = *storeType
+	config.Store.Watermark = *watermark
 	config.Generate.DataCenter = uint8(*dataCenter)
 	config.Generate.Step = *step
+	config.Generate.Worker = uint16(*worker)
+	config.Generate.TableMode = *tableMode
+	if config.Generate.TableMode == "" {
+		config.Generate.TableMode = defaultTableMode
+	}
+	if config.Generate.IdleSecond == 0 {
+		config.Generate.IdleSecond = defaultIdleSecond
+	}
+	if config.Generate.EvictSecond == 0 {
+		config.Generate.EvictSecond = defaultEvictSecond
+	}
 	if config.Store.MaxSecond == 0 {
 		config.Store.MaxSecond = defaultStoreMaxSecond
 	}
 	if config.Store.MinSecond == 0 {
 		config.Store.MinSecond = defaultStoreMinSecond
 	}
-	switch *storeType {
-	default:
-		log.Fatalln("store type Error", *storeType)
-	case "redis":
-		config.Store.URI = *storeURI
-		if config.Store.URI == "" {
-			config.Store.URI = defaultRedisAddress
-		}
-	case "etcd":
-		config.Store.URI = *storeURI
-		if config.Store.URI == "" {
-			config.Store.URI = defaultEtcdAddress
-		}
-	case "zk":
-		config.Store.URI = *storeURI
-		if config.Store.URI == "" {
-			config.Store.URI = defaultZKAddress
-		}
+	// 存储类型由store.NewStore检查，类型为空时使用uri的scheme，例如 redis://127.0.0.1:6379
+	config.Store.URI = *storeURI
+	if config.Store.URI == "" {
+		config.Store.URI = defaultStoreURIs[*storeType]
+	}
+	if config.Store.Type == "" && config.Store.URI == "" {
+		log.Fatalln("store type or store uri required")
+	}
+
+	// 拒绝会和已经发出的ID冲突的位分布
+	if err := config.Generate.Layout.Compatible(config.Generate.PreviousLayout, config.Generate.DataCenter); err != nil {
+		log.Fatalln("id layout error", err.Error())
+	}
+
+	if config.Store.Middleware.BreakerCooldownSecond == 0 {
+		config.Store.Middleware.BreakerCooldownSecond = defaultBreakerCooldownSecond
+	}
+	if config.Store.Middleware.RetryBaseMillisecond == 0 {
+		config.Store.Middleware.RetryBaseMillisecond = defaultRetryBaseMillisecond
+	}
+	if config.Store.Middleware.RetryMaxMillisecond == 0 {
+		config.Store.Middleware.RetryMaxMillisecond = defaultRetryMaxMillisecond
 	}
 
 	config.Store.Min = time.Duration(config.Store.MinSecond) * time.Second
@@ -174,6 +284,89 @@ func Init() Config {
 	return config
 }
 
+// TableConfigs 根据[[table]]生成service.WithTables的配置
+func (c Config) TableConfigs() []service.TableConfig {
+	tables := make([]service.TableConfig, len(c.Tables))
+	for i, t := range c.Tables {
+		tables[i] = service.TableConfig{
+			DB:                t.DB,
+			Table:             t.Table,
+			Step:              t.Step,
+			MinBufferTime:     time.Duration(t.MinSecond) * time.Second,
+			MaxBufferTime:     time.Duration(t.MaxSecond) * time.Second,
+			MaxStepMultiplier: t.MaxStepMultiplier,
+			Kind:              t.Kind,
+			MaxID:             t.MaxID,
+			RingSize:          t.RingSize,
+			ExpandSize:        t.ExpandSize,
+			RefillRatio:       t.RefillRatio,
+		}
+	}
+	return tables
+}
+
+// ServiceOptions 根据配置生成service.New的可选配置
+func (c Config) ServiceOptions() []service.Option {
+	return []service.Option{
+		service.WithLayout(c.Generate.Layout),
+		service.WithWorker(c.Generate.Worker),
+		service.WithTables(c.TableConfigs()...),
+		service.WithTableMode(c.Generate.TableMode),
+		service.WithIdleTime(time.Duration(c.Generate.IdleSecond) * time.Second),
+		service.WithEvictTTL(time.Duration(c.Generate.EvictSecond) * time.Second),
+		service.WithMaxGenerators(c.Generate.MaxGenerators),
+	}
+}
+
+// StoreOptions 根据配置生成store.NewStore的可选配置
+func (c Config) StoreOptions() []store.StoreOption {
+	var zkOpts []store.Option
+	if c.Store.ZK.User != "" {
+		zkOpts = append(zkOpts, store.Credentials(c.Store.ZK.User, c.Store.ZK.Password))
+	}
+	if c.Store.ZK.SessionSecond > 0 {
+		zkOpts = append(zkOpts, store.SessionTimeout(time.Duration(c.Store.ZK.SessionSecond)*time.Second))
+	}
+	if c.Store.ZK.ConnectSecond > 0 {
+		zkOpts = append(zkOpts, store.ConnectTimeout(time.Duration(c.Store.ZK.ConnectSecond)*time.Second))
+	}
+	if c.Store.ZK.UpgradeStep > 0 {
+		zkOpts = append(zkOpts, store.UpgradeStep(c.Store.ZK.UpgradeStep))
+	}
+	var etcdOpts []store.EtcdOption
+	etcd := c.Store.Etcd
+	if etcd.CertFile != "" || etcd.KeyFile != "" || etcd.CAFile != "" {
+		etcdOpts = append(etcdOpts, store.EtcdTLS(etcd.CertFile, etcd.KeyFile, etcd.CAFile))
+	}
+	if etcd.User != "" {
+		etcdOpts = append(etcdOpts, store.EtcdCredentials(etcd.User, etcd.Password))
+	}
+	if etcd.DialSecond > 0 {
+		etcdOpts = append(etcdOpts, store.EtcdDialTimeout(time.Duration(etcd.DialSecond)*time.Second))
+	}
+	if etcd.RequestMillisecond > 0 {
+		etcdOpts = append(etcdOpts, store.EtcdRequestTimeout(time.Duration(etcd.RequestMillisecond)*time.Millisecond))
+	}
+	if etcd.Root != "" {
+		etcdOpts = append(etcdOpts, store.EtcdRoot(etcd.Root))
+	}
+	// 熔断在最外层，统计重试之后的结果；超时在最内层，限制每一次请求
+	var mws []store.Middleware
+	mw := c.Store.Middleware
+	if mw.BreakerFailures > 0 {
+		mws = append(mws, store.CircuitBreaker(mw.BreakerFailures, time.Duration(mw.BreakerCooldownSecond)*time.Second))
+	}
+	if mw.RetryAttempts > 1 {
+		backoff := store.ExponentialBackoff(time.Duration(mw.RetryBaseMillisecond)*time.Millisecond,
+			time.Duration(mw.RetryMaxMillisecond)*time.Millisecond)
+		mws = append(mws, store.Retry(mw.RetryAttempts, backoff))
+	}
+	if mw.TimeoutMillisecond > 0 {
+		mws = append(mws, store.Timeout(time.Duration(mw.TimeoutMillisecond)*time.Millisecond))
+	}
+	return []store.StoreOption{store.WithZK(zkOpts...), store.WithEtcd(etcdOpts...), store.WithMiddleware(mws...)}
+}
+
 func envInt64(env string, fallback int64) int64 {
 	e := os.Getenv(env)
 	if e == "" {
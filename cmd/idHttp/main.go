@@ -2,15 +2,18 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/luw2007/rabbitid/cmd/idHttp/conf"
 	"github.com/luw2007/rabbitid/cmd/idHttp/service"
+	"github.com/luw2007/rabbitid/generator"
 	"github.com/luw2007/rabbitid/store"
 )
 
@@ -20,9 +23,30 @@ type Request struct {
 }
 
 type Response struct {
-	Code int64  `json:"code,omitempty"`
-	ID   int64  `json:"id"`
-	Msg  string `json:"msg,omitempty"`
+	Code int64   `json:"code,omitempty"`
+	ID   int64   `json:"id"`
+	IDs  []int64 `json:"ids,omitempty"`
+	Msg  string  `json:"msg,omitempty"`
+}
+
+// maxCount 批量获取ID的最大数量
+const maxCount = 10000
+
+// 未知table的错误码，table_mode 为 strict 或者 approval 时返回
+const (
+	codeUnknownTable = -2
+	codeTablePending = -3
+)
+
+// codeOf 发号错误对应的错误码，其他错误保持原来的 0
+func codeOf(msg string) int64 {
+	switch msg {
+	case service.ErrUnknownTable.Error():
+		return codeUnknownTable
+	case service.ErrTablePending.Error():
+		return codeTablePending
+	}
+	return 0
 }
 
 func main() {
@@ -30,8 +54,27 @@ func main() {
 	config := conf.Init()
 	logger := config.Logger.WithField("svc", "idhttp")
 
-	db := store.NewStore(config.Store.Type, config.Store.URI, config.Generate.DataCenter, logger)
-	svc := service.New(logger, db, config.Generate.Step, config.Generate.DataCenter, config.Store.Min, config.Store.Max)
+	db, err := store.NewStore(config.Store.Type, config.Store.URI, config.Generate.DataCenter, logger, config.StoreOptions()...)
+	if err != nil {
+		log.Fatalln("store init error", err.Error())
+	}
+	var wm *store.Watermark
+	if config.Store.Watermark != "" {
+		if wm, err = store.NewWatermark(db, config.Store.Watermark, logger); err != nil {
+			logger.WithError(err).Fatal("watermark init error")
+		}
+		db = wm
+	}
+	// newStore 切换存储时创建新的存储，和启动时使用相同的配置和本地水位
+	newStore := func(storeType, uri string) (store.Store, error) {
+		s, err := store.NewStore(storeType, uri, config.Generate.DataCenter, logger, config.StoreOptions()...)
+		if err != nil || wm == nil {
+			return s, err
+		}
+		return wm.With(s), nil
+	}
+	svc := service.New(logger, db, config.Generate.Step, config.Generate.DataCenter, config.Store.Min, config.Store.Max,
+		config.ServiceOptions()...)
 
 	g.GET("/last", func(c *gin.Context) {
 		app := c.Query("app")
@@ -64,6 +107,33 @@ func main() {
 		//c.String(200, fmt.Sprintf("{"))
 		c.JSON(200, Response{ID: id, Msg: msg})
 	})
+	g.GET("/stats", func(c *gin.Context) {
+		app := c.Query("app")
+		db := c.Query("db")
+		if app == "" || db == "" {
+			c.JSON(200, Response{Code: -1, Msg: "argument error"})
+			return
+		}
+		stats, msg := svc.Stats(c, app, db)
+		if msg != "" {
+			c.JSON(200, Response{Code: -1, Msg: msg})
+			return
+		}
+		c.JSON(200, stats)
+	})
+	g.GET("/decode", func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Query("id"), 10, 64)
+		if err != nil {
+			c.JSON(200, Response{Code: -1, Msg: "argument error"})
+			return
+		}
+		d, msg := svc.Decode(c, id, c.DefaultQuery("kind", generator.KindSegment))
+		if msg != "" {
+			c.JSON(200, Response{Code: -1, ID: id, Msg: msg})
+			return
+		}
+		c.JSON(200, d)
+	})
 	g.POST("/next", func(c *gin.Context) {
 		app := c.PostForm("app")
 		db := c.PostForm("db")
@@ -71,10 +141,26 @@ func main() {
 			c.JSON(200, Response{Code: -1, Msg: "argument error"})
 			return
 		}
-		id, msg := svc.Next(c, app, db)
-		c.JSON(200, Response{ID: id, Msg: msg})
+		count := c.Query("count")
+		if count == "" {
+			id, msg := svc.Next(c, app, db)
+			c.JSON(200, Response{Code: codeOf(msg), ID: id, Msg: msg})
+			return
+		}
+		n, err := strconv.ParseInt(count, 10, 64)
+		if err != nil || n < 1 || n > maxCount {
+			c.JSON(200, Response{Code: -1, Msg: "argument error"})
+			return
+		}
+		ids, msg := svc.NextN(c, app, db, n)
+		c.JSON(200, Response{Code: codeOf(msg), IDs: ids, Msg: msg})
 	})
 
+	// 没有配置token时不开启管理接口
+	if config.Server.AdminToken != "" {
+		adminRoutes(g, svc, config.Generate.DataCenter, config.Server.AdminToken, newStore)
+	}
+
 	errs := make(chan error)
 	go func() {
 		c := make(chan os.Signal)
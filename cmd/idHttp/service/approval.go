@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/luw2007/rabbitid/store"
+)
+
+// table的准入模式
+const (
+	// TableModeOpen 任意db和table都可以发号，默认
+	TableModeOpen = "open"
+	// TableModeStrict 只有[[table]]中声明的、已经审批的和存储中已经存在的table可以发号
+	TableModeStrict = "strict"
+	// TableModeApproval 和strict相同，未知的table记录到待审批列表，审批之后可以发号
+	TableModeApproval = "approval"
+)
+
+// maxPending 待审批列表的最大数量，超过后不再记录，防止随机的名称占用内存
+const maxPending = 1024
+
+var (
+	// ErrUnknownTable table没有声明也没有在存储中创建，错误以UNKNOWNTABLE开头，redis协议中作为错误码
+	ErrUnknownTable = errors.New("UNKNOWNTABLE table is not provisioned")
+	// ErrTablePending table等待审批
+	ErrTablePending = errors.New("PENDING table is waiting for approval")
+	// ErrTableMode 不支持的准入模式
+	ErrTableMode = errors.New("unknown table mode")
+)
+
+// A PendingTable 等待审批的table，Requests 请求次数
+type PendingTable struct {
+	DB       string    `json:"db"`
+	Table    string    `json:"table"`
+	Requests int64     `json:"requests"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+}
+
+// checkTableMode 检查准入模式
+func checkTableMode(mode string) error {
+	switch mode {
+	case TableModeOpen, TableModeStrict, TableModeApproval:
+		return nil
+	}
+	return fmt.Errorf("%v: %q", ErrTableMode, mode)
+}
+
+// allow 检查table是否可以发号，只在第一次加载时调用
+func (p *service) allow(ctx context.Context, name, db, table string) error {
+	if p.tableMode == TableModeOpen {
+		return nil
+	}
+	for _, t := range p.tables {
+		if t.match(db, table) {
+			return nil
+		}
+	}
+	p.approvalMu.Lock()
+	approved := p.approved[name]
+	p.approvalMu.Unlock()
+	if approved {
+		return nil
+	}
+	// 存储中已经存在的计数，不支持管理接口的存储只使用配置
+	if admin, err := store.AdminOf(p.Store()); err == nil {
+		_, err = admin.GetCounter(ctx, p.DataCenter, db, table)
+		if err == nil {
+			return nil
+		}
+		if err != store.ErrTableNotExists && err != store.ErrDBNotExists {
+			return err
+		}
+	}
+	if p.tableMode == TableModeApproval {
+		p.addPending(name, db, table)
+		return ErrTablePending
+	}
+	return ErrUnknownTable
+}
+
+func (p *service) addPending(name, db, table string) {
+	p.approvalMu.Lock()
+	defer p.approvalMu.Unlock()
+	now := time.Now()
+	t, ok := p.pending[name]
+	if !ok {
+		if len(p.pending) >= maxPending {
+			return
+		}
+		t = &PendingTable{DB: db, Table: table, First: now}
+		p.pending[name] = t
+	}
+	t.Requests++
+	t.Last = now
+}
+
+// Pending 等待审批的table，按照db和table排序
+func (p *service) Pending() []PendingTable {
+	p.approvalMu.Lock()
+	defer p.approvalMu.Unlock()
+	tables := make([]PendingTable, 0, len(p.pending))
+	for _, t := range p.pending {
+		tables = append(tables, *t)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		if tables[i].DB != tables[j].DB {
+			return tables[i].DB < tables[j].DB
+		}
+		return tables[i].Table < tables[j].Table
+	})
+	return tables
+}
+
+// Approve 审批table，存储支持管理接口时同时创建db。
+// 审批只保存在内存中，第一次发号后计数已经在存储中，重启后作为已经存在的table
+func (p *service) Approve(ctx context.Context, db, table string) error {
+	if strings.TrimSpace(db) == "" || strings.TrimSpace(table) == "" {
+		return ErrUnknownTable
+	}
+	if admin, err := store.AdminOf(p.Store()); err == nil {
+		if err = admin.CreateDB(ctx, p.DataCenter, db); err != nil {
+			return err
+		}
+	}
+	name := fmt.Sprintf("%s|%s", db, table)
+	p.approvalMu.Lock()
+	p.approved[name] = true
+	delete(p.pending, name)
+	p.approvalMu.Unlock()
+	return nil
+}
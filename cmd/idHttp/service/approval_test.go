@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/luw2007/rabbitid/store"
+)
+
+func TestService_TableModeStrict(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	db := store.NewMemory()
+	_, err := db.Range(context.TODO(), 0, testDB, "exists", testSize)
+	assert.NoError(t, err)
+	svc := New(logger, db, testSize, 0, 60, 600, WithTableMode(TableModeStrict),
+		WithTables(TableConfig{DB: testDB, Table: "declared_*"}))
+
+	// 声明的table和存储中已经存在的table可以发号
+	_, errMsg := svc.Next(context.TODO(), testDB, "declared_topic")
+	assert.Equal(t, errMsg, "")
+	id, errMsg := svc.Next(context.TODO(), testDB, "exists")
+	assert.Equal(t, errMsg, "")
+	assert.Equal(t, id, int64(testSize+1))
+
+	// 写错的名称不发号，也不创建计数
+	_, errMsg = svc.Next(context.TODO(), testDB, "exsits")
+	assert.Equal(t, errMsg, ErrUnknownTable.Error())
+	ids, errMsg := svc.NextN(context.TODO(), testDB, "exsits", 10)
+	assert.Equal(t, errMsg, ErrUnknownTable.Error())
+	assert.Empty(t, ids)
+	_, err = db.GetCounter(context.TODO(), 0, testDB, "exsits")
+	assert.Equal(t, err, store.ErrTableNotExists)
+	assert.Empty(t, svc.Pending())
+}
+
+func TestService_TableModeApproval(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	svc := New(logger, store.NewMemory(), testSize, 0, 60, 600, WithTableMode(TableModeApproval))
+
+	for i := 0; i < 2; i++ {
+		_, errMsg := svc.Next(context.TODO(), testDB, "new")
+		assert.Equal(t, errMsg, ErrTablePending.Error())
+	}
+	pending := svc.Pending()
+	assert.Len(t, pending, 1)
+	assert.Equal(t, pending[0].DB, testDB)
+	assert.Equal(t, pending[0].Table, "new")
+	assert.Equal(t, pending[0].Requests, int64(2))
+
+	assert.NoError(t, svc.Approve(context.TODO(), testDB, "new"))
+	assert.Empty(t, svc.Pending())
+	id, errMsg := svc.Next(context.TODO(), testDB, "new")
+	assert.Equal(t, errMsg, "")
+	assert.Equal(t, id, int64(1))
+}
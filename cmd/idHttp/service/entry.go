@@ -0,0 +1,71 @@
+package service
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/luw2007/rabbitid/generator"
+)
+
+// rateWindow 发号速度的平滑时间，越大越平滑，对突发的反应越慢
+const rateWindow = 10 * time.Second
+
+// An entry 发号器、最后一次使用的时间和发号速度。
+// used 为unix纳秒，issued 为已经发出的数量，放在前面的字段保证32位平台上的原子操作对齐
+type entry struct {
+	used   int64
+	issued int64
+	generator.Generator
+	// flight 正在进行的加载，同一个发号器同时只有一个加载
+	mu     sync.Mutex
+	flight *flight
+	// rate 发号速度的指数加权移动平均，单位 个/秒，由后台任务采样
+	rateMu    sync.Mutex
+	rate      float64
+	sampled   int64
+	sampledAt time.Time
+}
+
+func newEntry(g generator.Generator) *entry {
+	now := time.Now()
+	return &entry{used: now.UnixNano(), Generator: g, sampledAt: now}
+}
+
+// touch 记录使用时间
+func (e *entry) touch() {
+	atomic.StoreInt64(&e.used, time.Now().UnixNano())
+}
+
+// idle 距离最后一次使用的时间
+func (e *entry) idle(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&e.used)))
+}
+
+// issue 记录发出的数量
+func (e *entry) issue(n int) {
+	atomic.AddInt64(&e.issued, int64(n))
+}
+
+// sample 根据上次采样之后发出的数量更新发号速度，间隔越长权重越大
+func (e *entry) sample(now time.Time) {
+	e.rateMu.Lock()
+	defer e.rateMu.Unlock()
+	dt := now.Sub(e.sampledAt).Seconds()
+	if dt <= 0 {
+		return
+	}
+	issued := atomic.LoadInt64(&e.issued)
+	instant := float64(issued-e.sampled) / dt
+	alpha := 1 - math.Exp(-dt/rateWindow.Seconds())
+	e.rate += alpha * (instant - e.rate)
+	e.sampled, e.sampledAt = issued, now
+}
+
+// Rate 发号速度，单位 个/秒
+func (e *entry) Rate() float64 {
+	e.rateMu.Lock()
+	defer e.rateMu.Unlock()
+	return e.rate
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lruEntry 超过数量上限时按照最后使用时间淘汰
+type lruEntry struct {
+	name string
+	e    *entry
+	idle time.Duration
+}
+
+// evict 淘汰发号器，缓存中没有发出的号码不再使用，记录日志用于排查号码的空洞。
+// 正在使用旧发号器的请求不受影响，之后的请求重新创建发号器并从存储加载新的号段，不会重复
+func (p *service) evict(name string, e *entry, reason string) {
+	p.evictMu.Lock()
+	defer p.evictMu.Unlock()
+	// 已经被淘汰或者替换
+	if v, ok := p.Generator.Load(name); !ok || v != e {
+		return
+	}
+	p.Generator.Delete(name)
+	p.log.WithFields(logrus.Fields{
+		"action":    "evict",
+		"reason":    reason,
+		"db":        e.DB(),
+		"table":     e.Table(),
+		"last":      e.Last(),
+		"max":       e.Max(),
+		"remainder": e.Len(),
+	}).Info()
+}
+
+// evictOverflow 发号器数量超过上限时淘汰最久没有使用的发号器
+func (p *service) evictOverflow(entries []lruEntry) {
+	if p.maxGenerators <= 0 || len(entries) <= p.maxGenerators {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].idle > entries[j].idle
+	})
+	for _, l := range entries[:len(entries)-p.maxGenerators] {
+		p.evict(l.name, l.e, "max")
+	}
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/luw2007/rabbitid/store"
+)
+
+func TestService_EvictTTL(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	svc := New(logger, store.NewMemory(), testSize, 0, 60, 600, WithEvictTTL(100*time.Millisecond)).(*service)
+
+	id, errMsg := svc.Next(context.TODO(), testDB, "ttl")
+	assert.Equal(t, errMsg, "")
+	assert.Equal(t, id, int64(1))
+	time.Sleep(300 * time.Millisecond)
+	_, ok := svc.Generator.Load(testDB + "|ttl")
+	assert.False(t, ok)
+
+	// 重新创建的发号器从存储加载新的号段，不会重复
+	id, errMsg = svc.Next(context.TODO(), testDB, "ttl")
+	assert.Equal(t, errMsg, "")
+	assert.True(t, id > testSize)
+}
+
+func TestService_EvictMax(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	svc := New(logger, store.NewMemory(), testSize, 0, 60, 600, WithMaxGenerators(2)).(*service)
+
+	// 超过上限时淘汰最久没有使用的发号器
+	for _, table := range []string{"old", "new", "newest"} {
+		_, errMsg := svc.Next(context.TODO(), testDB, table)
+		assert.Equal(t, errMsg, "")
+	}
+	time.Sleep(200 * time.Millisecond)
+	_, ok := svc.Generator.Load(testDB + "|old")
+	assert.False(t, ok)
+	for _, table := range []string{"new", "newest"} {
+		_, ok = svc.Generator.Load(testDB + "|" + table)
+		assert.True(t, ok)
+	}
+}
+
+func TestService_DeleteTable(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	db := store.NewMemory()
+	svc := New(logger, db, testSize, 0, 60, 600, WithEvictTTL(100*time.Millisecond))
+
+	// 发号器还在使用时拒绝删除
+	_, errMsg := svc.Next(context.TODO(), testDB, "del")
+	assert.Equal(t, errMsg, "")
+	assert.Equal(t, svc.DeleteTable(context.TODO(), testDB, "del"), ErrTableLoaded)
+	_, err := db.GetCounter(context.TODO(), 0, testDB, "del")
+	assert.NoError(t, err)
+
+	// 淘汰之后可以删除
+	time.Sleep(300 * time.Millisecond)
+	assert.NoError(t, svc.DeleteTable(context.TODO(), testDB, "del"))
+	_, err = db.GetCounter(context.TODO(), 0, testDB, "del")
+	assert.Equal(t, err, store.ErrTableNotExists)
+}
+
+// deleteStore DeleteTable 在release关闭之前阻塞，记录Range的次数
+type deleteStore struct {
+	*store.Memory
+	ranges   int64
+	deleting chan struct{}
+	release  chan struct{}
+}
+
+func (p *deleteStore) Range(ctx context.Context, dataCenter uint8, db, table string, size int64) (int64, error) {
+	atomic.AddInt64(&p.ranges, 1)
+	return p.Memory.Range(ctx, dataCenter, db, table, size)
+}
+
+func (p *deleteStore) DeleteTable(ctx context.Context, dataCenter uint8, db, table string) error {
+	close(p.deleting)
+	<-p.release
+	return p.Memory.DeleteTable(ctx, dataCenter, db, table)
+}
+
+func TestService_DeleteTableLoading(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	db := &deleteStore{Memory: store.NewMemory(), deleting: make(chan struct{}), release: make(chan struct{})}
+	_, err := db.Memory.Range(context.TODO(), 0, testDB, "del", 100)
+	assert.NoError(t, err)
+	svc := New(logger, db, testSize, 0, 60, 600)
+
+	deleted := make(chan error)
+	go func() { deleted <- svc.DeleteTable(context.TODO(), testDB, "del") }()
+	<-db.deleting
+
+	// 删除期间新建的发号器等待删除完成后再加载，不会使用删除之前的计数
+	ids := make(chan int64)
+	go func() {
+		id, _ := svc.Next(context.TODO(), testDB, "del")
+		ids <- id
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, atomic.LoadInt64(&db.ranges), int64(0))
+	close(db.release)
+	assert.NoError(t, <-deleted)
+	assert.Equal(t, <-ids, int64(1))
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/luw2007/rabbitid/generator"
+	"github.com/luw2007/rabbitid/store"
+)
+
+// 切换存储的状态
+const (
+	MigrationIdle    = "idle"
+	MigrationRunning = "running"
+	MigrationDone    = "done"
+	MigrationFailed  = "failed"
+)
+
+var (
+	// ErrMigrationRunning 已经在切换存储
+	ErrMigrationRunning = errors.New("migration is running")
+	// ErrMigrationNoAdmin 旧存储不支持store.Admin，读不到已经淘汰的table的计数，迁移后会重复发号
+	ErrMigrationNoAdmin = errors.New("migration requires the current store to support admin")
+)
+
+// MigrationStatus 切换存储的进度，Tables 需要迁移的table数量，Migrated 已经完成的数量
+type MigrationStatus struct {
+	State    string    `json:"state"`
+	Tables   int       `json:"tables"`
+	Migrated int       `json:"migrated"`
+	Margin   int64     `json:"margin"`
+	Error    string    `json:"error,omitempty"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+}
+
+// migrateKey 需要迁移的table
+type migrateKey struct {
+	db, table string
+}
+
+// Migrate 在线切换存储，切换过程中暂停从存储加载，缓存中的号码继续发放。
+// 新存储中每个table的计数拉高到已经发出的最大值加上margin：最大值取本进程所有发号器的Max()
+// 和旧存储中所有table的计数，本进程没有加载或者已经淘汰的table也会迁移，旧存储不支持store.Admin时拒绝切换。
+// 多个进程共用存储时，margin 需要大于其他进程切换前可能分配的数量。
+// 成功后关闭旧的存储，失败时关闭新的存储
+func (p *service) Migrate(ctx context.Context, to store.Store, margin int64) error {
+	if !p.startMigration(margin) {
+		store.Close(to)
+		return ErrMigrationRunning
+	}
+	// 等待正在进行的加载完成后暂停加载，之后发号器的Max不再变化
+	resume := make(chan struct{})
+	p.storeMu.Lock()
+	from := p.db
+	p.resume = resume
+	p.storeMu.Unlock()
+
+	err := p.migrate(ctx, from, to, margin)
+
+	p.storeMu.Lock()
+	if err == nil {
+		p.db = to
+	}
+	p.resume = nil
+	p.storeMu.Unlock()
+	close(resume)
+
+	closed := from
+	if err != nil {
+		closed = to
+	}
+	if cerr := store.Close(closed); cerr != nil {
+		p.log.WithField("action", "migrate").WithError(cerr).Error("close store")
+	}
+	p.finishMigration(err)
+	return err
+}
+
+func (p *service) migrate(ctx context.Context, from, to store.Store, margin int64) error {
+	l := p.log.WithFields(logrus.Fields{"action": "migrate", "margin": margin})
+	maxes := make(map[migrateKey]int64)
+	p.Generator.Range(func(key, value interface{}) bool {
+		// 时间戳发号不使用存储
+		if g, ok := value.(*entry).Generator.(*generator.Segment); ok {
+			maxes[migrateKey{g.DB(), g.Table()}] = g.Max()
+		}
+		return true
+	})
+	if _, err := store.AdminOf(from); err != nil {
+		l.WithError(err).Error("admin of current store")
+		return ErrMigrationNoAdmin
+	}
+	counters, err := store.Export(ctx, from, p.DataCenter)
+	if err != nil {
+		l.WithError(err).Error("export counters")
+		return err
+	}
+	for _, c := range counters {
+		if key := (migrateKey{c.DB, c.Table}); c.Value > maxes[key] {
+			maxes[key] = c.Value
+		}
+	}
+	p.updateMigration(func(m *MigrationStatus) { m.Tables = len(maxes) })
+
+	// 需要提前创建db的存储先创建db
+	toAdmin, adminErr := store.AdminOf(to)
+	created := make(map[string]bool)
+	for key, max := range maxes {
+		if adminErr == nil && !created[key.db] {
+			if err := toAdmin.CreateDB(ctx, p.DataCenter, key.db); err != nil {
+				l.WithField("db", key.db).WithError(err).Error("create db")
+				return err
+			}
+			created[key.db] = true
+		}
+		if err := store.Raise(ctx, to, p.DataCenter, key.db, key.table, max+margin); err != nil {
+			l.WithFields(logrus.Fields{"db": key.db, "table": key.table, "max": max}).WithError(err).Error("raise")
+			return err
+		}
+		p.updateMigration(func(m *MigrationStatus) { m.Migrated++ })
+	}
+	l.WithField("tables", len(maxes)).Info("migrated")
+	return nil
+}
+
+// Migration 切换存储的进度
+func (p *service) Migration() MigrationStatus {
+	p.migrationMu.Lock()
+	defer p.migrationMu.Unlock()
+	return p.migration
+}
+
+func (p *service) startMigration(margin int64) bool {
+	p.migrationMu.Lock()
+	defer p.migrationMu.Unlock()
+	if p.migration.State == MigrationRunning {
+		return false
+	}
+	p.migration = MigrationStatus{State: MigrationRunning, Margin: margin, Start: time.Now()}
+	return true
+}
+
+func (p *service) updateMigration(fn func(*MigrationStatus)) {
+	p.migrationMu.Lock()
+	fn(&p.migration)
+	p.migrationMu.Unlock()
+}
+
+func (p *service) finishMigration(err error) {
+	p.updateMigration(func(m *MigrationStatus) {
+		m.State = MigrationDone
+		if err != nil {
+			m.State = MigrationFailed
+			m.Error = err.Error()
+		}
+		m.End = time.Now()
+	})
+}
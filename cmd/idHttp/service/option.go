@@ -0,0 +1,59 @@
+package service
+
+import (
+	"time"
+
+	"github.com/luw2007/rabbitid/generator"
+)
+
+// Option 服务的可选配置
+type Option func(*service)
+
+// WithLayout 指定ID的位分布，默认使用generator.DefaultLayout
+func WithLayout(layout generator.IDLayout) Option {
+	return func(s *service) {
+		s.layout = layout
+	}
+}
+
+// WithWorker 时间戳发号的进程ID，同一个机房的进程需要不同
+func WithWorker(worker uint16) Option {
+	return func(s *service) {
+		s.worker = worker
+	}
+}
+
+// WithTableMode table的准入模式，TableModeOpen、TableModeStrict 或者 TableModeApproval，默认TableModeOpen
+func WithTableMode(mode string) Option {
+	return func(s *service) {
+		s.tableMode = mode
+	}
+}
+
+// WithTables 单个table的配置，按顺序使用第一个匹配的配置
+func WithTables(tables ...TableConfig) Option {
+	return func(s *service) {
+		s.tables = append(s.tables, tables...)
+	}
+}
+
+// WithIdleTime 超过idle没有使用的table后台不再预加载，0 表示一直预加载
+func WithIdleTime(idle time.Duration) Option {
+	return func(s *service) {
+		s.idleTime = idle
+	}
+}
+
+// WithEvictTTL 超过ttl没有使用的发号器被淘汰，缓存中的号码不再发放，0 表示不淘汰
+func WithEvictTTL(ttl time.Duration) Option {
+	return func(s *service) {
+		s.evictTTL = ttl
+	}
+}
+
+// WithMaxGenerators 发号器的数量上限，超过后淘汰最久没有使用的发号器，0 表示不限制
+func WithMaxGenerators(n int) Option {
+	return func(s *service) {
+		s.maxGenerators = n
+	}
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"time"
+)
+
+// A flight 一次加载，done 在加载完成后关闭，err 为加载的结果，关闭后可以读取
+type flight struct {
+	done chan struct{}
+	err  error
+}
+
+// refill 没有正在进行的加载时启动加载，返回当前的加载。
+// 请求和后台任务共用同一个加载，加载使用独立的超时时间，不会因为单个请求取消而失败
+func (p *service) refill(e *entry) *flight {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.flight != nil {
+		return e.flight
+	}
+	f := &flight{done: make(chan struct{})}
+	e.flight = f
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultGeneratorLoadTimeout)
+		_, f.err = p.expand(ctx, e)
+		cancel()
+		e.mu.Lock()
+		e.flight = nil
+		e.mu.Unlock()
+		close(f.done)
+	}()
+	return f
+}
+
+// wait 等待发号器加载完成，ctx 结束时返回ctx.Err()，不需要加载时直接返回
+func (p *service) wait(ctx context.Context, e *entry) error {
+	if !e.NeedExpand() {
+		return nil
+	}
+	f := p.refill(e)
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitTimeout 后台任务等待加载完成
+func (p *service) waitTimeout(e *entry, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return p.wait(ctx, e)
+}
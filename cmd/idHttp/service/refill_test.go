@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/luw2007/rabbitid/store"
+)
+
+// blockingStore Range 在release关闭之前阻塞，ctx 结束时返回ctx.Err()
+type blockingStore struct {
+	store.Store
+	ranges  int64
+	release chan struct{}
+}
+
+func (p *blockingStore) Range(ctx context.Context, dataCenter uint8, db, table string, size int64) (int64, error) {
+	atomic.AddInt64(&p.ranges, 1)
+	select {
+	case <-p.release:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	return p.Store.Range(ctx, dataCenter, db, table, size)
+}
+
+func TestService_RefillSingleflight(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	db := &blockingStore{Store: store.NewMemory(), release: make(chan struct{})}
+	svc := New(logger, db, testSize, 0, 60, 600)
+
+	// 并发的请求和后台任务等待同一个加载
+	var wg sync.WaitGroup
+	ids := make([]int64, 20)
+	msgs := make([]string, len(ids))
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids[i], msgs[i] = svc.Next(context.TODO(), testDB, "flight")
+		}(i)
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, atomic.LoadInt64(&db.ranges), int64(1))
+	close(db.release)
+	wg.Wait()
+
+	seen := make(map[int64]bool)
+	for i, id := range ids {
+		assert.Equal(t, msgs[i], "")
+		assert.False(t, seen[id])
+		seen[id] = true
+	}
+}
+
+func TestService_RefillContext(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	db := &blockingStore{Store: store.NewMemory(), release: make(chan struct{})}
+	svc := New(logger, db, testSize, 0, 60, 600)
+
+	// 存储没有返回时按照请求的ctx返回，不返回buff empty
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	_, errMsg := svc.Next(ctx, testDB, "slow")
+	assert.Equal(t, errMsg, context.DeadlineExceeded.Error())
+	assert.True(t, time.Since(begin) < defaultGeneratorLoadTimeout)
+	ids, errMsg := svc.NextN(ctx, testDB, "slow", 10)
+	assert.Equal(t, errMsg, context.DeadlineExceeded.Error())
+	assert.Empty(t, ids)
+
+	close(db.release)
+	id, errMsg := svc.Next(context.TODO(), testDB, "slow")
+	assert.Equal(t, errMsg, "")
+	assert.True(t, id > 0)
+}
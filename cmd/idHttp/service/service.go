@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"sync"
 	"time"
 
@@ -19,65 +20,166 @@ import (
 type Service interface {
 	// NextID 通过服务名称获取自增ID和错误
 	Next(ctx context.Context, db, table string) (id int64, msg string)
+	// NextN 通过服务名称批量获取自增ID和错误，返回的数量可能小于n
+	NextN(ctx context.Context, db, table string, n int64) (ids []int64, msg string)
 	// Last 通过服务名获取最后发放的ID和错误
 	Last(ctx context.Context, db, table string) (id int64, msg string)
 	// Last 通过服务名获取剩余的ID数量和错误
 	Remainder(ctx context.Context, db, table string) (id int64, msg string)
 	// Max 通过服务名获取可生成的最大ID和错误
 	Max(ctx context.Context, db, table string) (id int64, msg string)
+	// Decode 按照发号器类型解析ID，得到机房、计数等信息和错误
+	Decode(ctx context.Context, id int64, kind string) (d generator.DecodedID, msg string)
+	// Store 当前使用的存储
+	Store() store.Store
+	// Migrate 在线切换存储，新的存储从已经发出的最大值加上margin开始
+	Migrate(ctx context.Context, to store.Store, margin int64) error
+	// Migration 切换存储的进度
+	Migration() MigrationStatus
+	// Pending 等待审批的table，只在TableModeApproval下记录
+	Pending() []PendingTable
+	// Approve 审批table，之后可以发号
+	Approve(ctx context.Context, db, table string) error
+	// DeleteTable 删除存储中的计数，发号器还在使用时返回ErrTableLoaded
+	DeleteTable(ctx context.Context, db, table string) error
+	// Stats 通过服务名获取发号器的加载数量、发号速度等状态和错误
+	Stats(ctx context.Context, db, table string) (s TableStats, msg string)
+}
+
+// A TableStats 发号器的状态，Step 最近一次加载的数量，Rate 发号速度（个/秒），
+// Remainder 缓存中剩余的数量，Max 见generator.Generator的Max，Idle 距离最后一次发号的秒数
+type TableStats struct {
+	DB        string  `json:"db"`
+	Table     string  `json:"table"`
+	Step      int64   `json:"step"`
+	Rate      float64 `json:"rate"`
+	Remainder int64   `json:"remainder"`
+	Max       int64   `json:"max"`
+	Idle      float64 `json:"idle"`
 }
 
 // A service 递增生成ID
 type service struct {
-	Generator  *sync.Map
-	Store      store.Store
+	Generator *sync.Map
+	// storeMu 保护db和resume，expand 在加载期间持有读锁。
+	// 切换存储时resume不为nil，expand 等待切换完成后从新的存储加载，切换完成后关闭resume
+	storeMu    sync.RWMutex
+	db         store.Store
+	resume     chan struct{}
 	Step       int64
 	DataCenter uint8
 	// minBufferTime, maxBufferTime 表示缓存最长和最短支持时间，用来调整每次缓存数量
 	minBufferTime time.Duration
 	maxBufferTime time.Duration
-	log           *logrus.Entry
+	// layout ID的位分布
+	layout generator.IDLayout
+	// worker 时间戳发号的进程ID
+	worker uint16
+	// tables 单个table的配置，按顺序匹配
+	tables []TableConfig
+	// tableMode table的准入模式，approved 已经审批的table，pending 等待审批的table
+	tableMode  string
+	approvalMu sync.Mutex
+	approved   map[string]bool
+	pending    map[string]*PendingTable
+	// idleTime 超过该时间没有使用的table不再预加载，evictTTL 超过该时间没有使用的发号器被淘汰，
+	// maxGenerators 发号器的数量上限，超过后淘汰最久没有使用的，都为0时不限制
+	idleTime      time.Duration
+	evictTTL      time.Duration
+	maxGenerators int
+	evictMu       sync.Mutex
+	// migration 切换存储的进度
+	migrationMu sync.Mutex
+	migration   MigrationStatus
+	log         *logrus.Entry
 }
 
 const (
-	// retries 重试次数
+	// retries 发号器为空时等待加载的次数
 	retries = 5
 	// processTaskTicker 后台任务间隔
 	processTaskTicker = time.Millisecond * 50
+	// maxHeadroomExpands 缓存的余量最多为最大加载数量的倍数
+	maxHeadroomExpands = 3
 	// 默认存储获取超时时间
 	defaultGeneratorLoadTimeout = time.Millisecond * 200
 )
 
-// ErrEmpty 查询ID 的类型不存在
-var ErrEmpty = errors.New("类型不存在")
+var (
+	// ErrEmpty 查询ID 的类型不存在
+	ErrEmpty = errors.New("类型不存在")
+	// ErrTableLoaded 发号器还在内存中，删除计数后新的号段会和缓存中的号码重复
+	ErrTableLoaded = errors.New("table is loaded, wait for it to be evicted")
+)
 
 // New 生成新的ID服务
-func New(logger *logrus.Entry, store store.Store, size int64, dc uint8, min, max time.Duration) Service {
-	if int64(dc) > generator.DataCenterMask {
-		log.Fatalln("dateCenter critical:", dc)
-	}
+func New(logger *logrus.Entry, store store.Store, size int64, dc uint8, min, max time.Duration, opts ...Option) Service {
 	logger = logger.WithFields(logrus.Fields{"svc": "id", "dataCenter": dc})
 	service := &service{
 		Generator:     new(sync.Map),
-		Store:         store,
+		db:            store,
 		Step:          size,
 		DataCenter:    dc,
 		minBufferTime: min,
 		maxBufferTime: max,
+		layout:        generator.DefaultLayout,
+		tableMode:     TableModeOpen,
+		approved:      make(map[string]bool),
+		pending:       make(map[string]*PendingTable),
+		migration:     MigrationStatus{State: MigrationIdle},
 		log:           logger,
 	}
+	for _, opt := range opts {
+		opt(service)
+	}
+	if int64(dc) > service.layout.DataCenterMask() {
+		log.Fatalln("dateCenter critical:", dc)
+	}
+	if err := checkTableMode(service.tableMode); err != nil {
+		log.Fatalln("table mode error:", err.Error())
+	}
+	for _, t := range service.tables {
+		if err := t.Validate(); err != nil {
+			log.Fatalln("table config error:", err.Error())
+		}
+	}
 	logger.Info("new id service")
 	go service.process()
 	return service
 }
 
-// expand 加载更多的数据
-func (p *service) expand(ctx context.Context, g generator.Generator) (int64, error) {
-	size := p.newSize(g)
-	min, err := p.Store.Range(ctx, p.DataCenter, g.DB(), g.Table(), size)
+// expand 加载更多的数据，计数达到MaxID时返回ErrIDLimit
+func (p *service) expand(ctx context.Context, g *entry) (int64, error) {
+	c := p.tableConfig(g.DB(), g.Table())
+	if c.MaxID > 0 && g.Max() >= c.MaxID {
+		return 0, ErrIDLimit
+	}
+	size := p.newSize(g, c)
+	p.storeMu.RLock()
+	for p.resume != nil {
+		resume := p.resume
+		p.storeMu.RUnlock()
+		select {
+		case <-resume:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		p.storeMu.RLock()
+	}
+	defer p.storeMu.RUnlock()
+	min, err := p.db.Range(ctx, p.DataCenter, g.DB(), g.Table(), size)
 	if err != nil {
 		return 0, err
 	}
+	// 超过上限的部分不再发放
+	if c.MaxID > 0 {
+		if min >= c.MaxID {
+			return 0, ErrIDLimit
+		}
+		if min+size > c.MaxID {
+			size = c.MaxID - min
+		}
+	}
 	if err = g.Expand(min, size); err != nil {
 		p.log.WithField("action", "expand").WithError(err).Error()
 		return min, nil
@@ -96,57 +198,91 @@ func (p *service) Last(ctx context.Context, db, table string) (int64, string) {
 	return g.Last(), ""
 }
 
-// newSize 重新计算size
-func (p service) newSize(g generator.Generator) int64 {
-	size := g.Step()
-	duration := time.Since(g.UpdateTime())
-
-	// [0, minBufferTime) 表示当前消费者饥饿，增加获取数量
-	if duration < p.minBufferTime {
-		size *= 2
-		// [maxBufferTime, ∞) 表示当前消费者饱和，减少获取数量
-	} else if duration > p.maxBufferTime {
-		size /= 2
+// newSize 按照发号速度计算每次加载的数量，一次加载支持MaxBufferTime，
+// 不小于Step，不大于Step的MaxStepMultiplier倍，还没有速度时使用Step
+func (p *service) newSize(e *entry, c TableConfig) int64 {
+	size := int64(math.Ceil(e.Rate() * c.MaxBufferTime.Seconds()))
+	if size < c.Step {
+		size = c.Step
 	}
-	// 每次加载不小于初始值
-	if size < p.Step || size > p.Step*1024 {
-		size = g.Step()
+	if max := c.Step * c.MaxStepMultiplier; size > max {
+		size = max
 	}
 	return size
 }
 
-// NextID 获取新的ID, 没有初始化从store中获取
-func (p *service) Next(ctx context.Context, db, table string) (v int64, msg string) {
-	var g generator.Generator
-	name := fmt.Sprintf("%s|%s", db, table)
+// needExpand 发号器自己的判断（RefillRatio、ExpandSize）需要加载，或者剩余的号码按照发号速度不够MinBufferTime时加载，
+// 保证缓存中至少有MinBufferTime的余量。余量不超过3次最大的加载，防止环写满
+func (p *service) needExpand(e *entry, c TableConfig) bool {
+	if e.NeedExpand() {
+		return true
+	}
+	rate := e.Rate()
+	// 时间戳发号不需要加载
+	if rate == 0 || c.Kind == generator.KindSnowflake {
+		return false
+	}
+	headroom := rate * c.MinBufferTime.Seconds()
+	if max := float64(c.Step * c.MaxStepMultiplier * maxHeadroomExpands); headroom > max {
+		headroom = max
+	}
+	return float64(e.Len()) < headroom
+}
+
+// load 获取发号器, 没有初始化从store中获取，table不允许发号时返回错误
+func (p *service) load(ctx context.Context, name, db, table string) (*entry, error) {
 	gs, ok := p.Generator.Load(name)
-	// 不存在初始化
 	if ok {
-		g = gs.(generator.Generator)
-	} else {
-		g = generator.NewSegment(p.DataCenter, db, table, p.Step)
-		// 防止竞争生成多个generator
-		old, loaded := p.Generator.LoadOrStore(name, g)
-		if loaded {
-			g = old.(generator.Generator)
-		} else {
-			p.log.WithFields(logrus.Fields{"db": db, "table": table, "expand": "init"})
-			p.expand(ctx, g)
-		}
+		e := gs.(*entry)
+		e.touch()
+		return e, nil
+	}
+	if err := p.allow(ctx, name, db, table); err != nil {
+		return nil, err
+	}
+	// 不存在初始化，使用table的配置
+	g := newEntry(p.newGenerator(p.tableConfig(db, table)))
+	// 防止竞争生成多个generator
+	old, loaded := p.Generator.LoadOrStore(name, g)
+	if loaded {
+		e := old.(*entry)
+		e.touch()
+		return e, nil
 	}
-	var err error
-	for i := 0; i < retries; i++ {
+	// 时间戳发号不需要从存储加载
+	if !g.NeedExpand() {
+		return g, nil
+	}
+	p.log.WithFields(logrus.Fields{"db": db, "table": table, "expand": "init"})
+	// 请求在发号器为空时等待加载完成
+	p.refill(g)
+	return g, nil
+}
+
+// NextID 获取新的ID, 没有初始化从store中获取。
+// 发号器为空时等待加载完成，直到ctx结束，同一个发号器同时只有一个加载
+func (p *service) Next(ctx context.Context, db, table string) (v int64, msg string) {
+	name := fmt.Sprintf("%s|%s", db, table)
+	g, err := p.load(ctx, name, db, table)
+	if err != nil {
+		return 0, err.Error()
+	}
+	for waits := 0; waits < retries; {
 		v, err = g.Next()
 		switch err {
 		case nil:
+			g.issue(1)
 			return v, ""
 		case generator.ErrEmpty:
-			// 可用数据为空的时候再检查一次，防止并发导致多次expand
-			if g.NeedExpand() {
-				p.log.WithFields(logrus.Fields{"name": name, "expand": "front", "cause": "empty"})
-				p.expand(ctx, g)
+			// 读游标移动到下一个缓存时也会返回ErrEmpty，还有缓存时直接重试
+			if g.Len() > 0 {
+				continue
+			}
+			waits++
+			p.log.WithFields(logrus.Fields{"name": name, "expand": "front", "cause": "empty"})
+			if waitErr := p.wait(ctx, g); waitErr != nil {
+				return 0, waitErr.Error()
 			}
-			continue
 		default:
 			p.log.WithFields(logrus.Fields{"name": name, "expand": "front", "cause": err.Error(), "len": g.Len()})
 			return v, err.Error()
@@ -156,6 +292,48 @@ func (p *service) Next(ctx context.Context, db, table string) (v int64, msg stri
 	return v, err.Error()
 }
 
+// NextN 批量获取新的ID, 没有初始化从store中获取。
+// 当前缓存不足时等待加载，ctx 结束或者加载失败时返回已经获取到的ID
+func (p *service) NextN(ctx context.Context, db, table string, n int64) (ids []int64, msg string) {
+	name := fmt.Sprintf("%s|%s", db, table)
+	g, err := p.load(ctx, name, db, table)
+	if err != nil {
+		return nil, err.Error()
+	}
+	ids = make([]int64, 0, n)
+	for waits := 0; waits < retries && int64(len(ids)) < n; {
+		var got []int64
+		got, err = g.NextN(n - int64(len(ids)))
+		ids = append(ids, got...)
+		g.issue(len(got))
+		switch err {
+		case nil:
+			continue
+		case generator.ErrEmpty:
+			if g.Len() > 0 {
+				continue
+			}
+			waits++
+			p.log.WithFields(logrus.Fields{"name": name, "expand": "front", "cause": "empty", "count": n})
+			if waitErr := p.wait(ctx, g); waitErr != nil {
+				// 达到上限或者超时时返回已经获取到的ID
+				if len(ids) == 0 {
+					return nil, waitErr.Error()
+				}
+				return ids, ""
+			}
+		default:
+			p.log.WithFields(logrus.Fields{"name": name, "expand": "front", "cause": err.Error(), "len": g.Len()})
+			return ids, err.Error()
+		}
+	}
+	if len(ids) == 0 && err != nil {
+		p.log.WithFields(logrus.Fields{"name": name, "expand": "front", "cause": err.Error()})
+		return nil, err.Error()
+	}
+	return ids, ""
+}
+
 // Remainder 余数
 func (p *service) Remainder(ctx context.Context, db, table string) (int64, string) {
 	name := fmt.Sprintf("%s|%s", db, table)
@@ -178,34 +356,102 @@ func (p *service) Max(ctx context.Context, db, table string) (int64, string) {
 	return g.Max(), ""
 }
 
+// Stats 发号器的状态
+func (p *service) Stats(ctx context.Context, db, table string) (TableStats, string) {
+	name := fmt.Sprintf("%s|%s", db, table)
+	gs, ok := p.Generator.Load(name)
+	if !ok {
+		return TableStats{}, ErrEmpty.Error()
+	}
+	e := gs.(*entry)
+	return TableStats{
+		DB:        db,
+		Table:     table,
+		Step:      e.Step(),
+		Rate:      e.Rate(),
+		Remainder: e.Len(),
+		Max:       e.Max(),
+		Idle:      e.idle(time.Now()).Seconds(),
+	}, ""
+}
+
+// Store 当前使用的存储
+func (p *service) Store() store.Store {
+	p.storeMu.RLock()
+	defer p.storeMu.RUnlock()
+	return p.db
+}
+
+// DeleteTable 删除存储中的计数，之后从0开始发号，只能删除已经淘汰的发号器。
+// 检查和删除期间持有storeMu的写锁：加载之前发号器已经放入Generator，正在加载的table会被拒绝，
+// 检查之后新建的发号器等待删除完成后再从存储加载
+func (p *service) DeleteTable(ctx context.Context, db, table string) error {
+	p.storeMu.Lock()
+	defer p.storeMu.Unlock()
+	if _, ok := p.Generator.Load(fmt.Sprintf("%s|%s", db, table)); ok {
+		return ErrTableLoaded
+	}
+	admin, err := store.AdminOf(p.db)
+	if err != nil {
+		return err
+	}
+	return admin.DeleteTable(ctx, p.DataCenter, db, table)
+}
+
+// Decode 使用服务的位分布解析ID
+func (p *service) Decode(_ context.Context, id int64, kind string) (generator.DecodedID, string) {
+	d, err := p.layout.Decode(id, kind)
+	if err != nil {
+		return d, err.Error()
+	}
+	return d, ""
+}
+
 // process 后台任务，加载更多数据
 func (p *service) process() {
 	l := p.log.WithField("msg", "process start")
 	for {
 		time.Sleep(processTaskTicker)
 		ctx, cancel := context.WithTimeout(context.Background(), defaultGeneratorLoadTimeout)
-		err := p.Store.Ping(ctx)
+		db := p.Store()
+		err := db.Ping(ctx)
+		cancel()
+		// 存储不可用时不再加载，例如zk会话过期，等待恢复
 		if err != nil {
 			l.WithField("expend", "ping").WithError(err).Error()
+			continue
 		}
-		cancel()
+		now := time.Now()
+		var entries []lruEntry
 		p.Generator.Range(func(key, value interface{}) bool {
-			g := value.(generator.Generator)
-			if p.Store.BlockDB(p.DataCenter, g.DB()) {
+			g := value.(*entry)
+			g.sample(now)
+			idle := g.idle(now)
+			if p.evictTTL > 0 && idle > p.evictTTL {
+				p.evict(key.(string), g, "ttl")
+				return true
+			}
+			if p.maxGenerators > 0 {
+				entries = append(entries, lruEntry{name: key.(string), e: g, idle: idle})
+			}
+			// 没有使用的table不预加载，再次使用时由请求加载
+			if p.idleTime > 0 && idle > p.idleTime {
+				return true
+			}
+			if db.BlockDB(p.DataCenter, g.DB()) {
 				return true
 			}
-			// 当剩余数量多少的时候，再次填充
-			if !g.NeedExpand() {
+			// 剩余数量不够MinBufferTime的时候，再次填充
+			if !p.needExpand(g, p.tableConfig(g.DB(), g.Table())) {
 				return true
 			}
-			ctx, cancel := context.WithTimeout(context.Background(), defaultGeneratorLoadTimeout)
 			p.log.WithFields(logrus.Fields{"db": g.DB(), "table": g.Table(), "expand": "process", "len": g.Len(), "size": g.Step(), "last": g.Last()})
-			_, err := p.expand(ctx, g)
-			cancel()
-			if err != nil {
+			// 和请求共用同一个加载
+			if err := p.waitTimeout(g, defaultGeneratorLoadTimeout); err != nil {
 				p.log.WithField("expand", "expand").WithError(err)
 			}
 			return true
 		})
+		p.evictOverflow(entries)
 	}
 }
@@ -3,11 +3,15 @@ package service
 import (
 	"context"
 	"fmt"
-	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/luw2007/rabbitid/generator"
+	"github.com/luw2007/rabbitid/store"
 )
 
 const (
@@ -15,35 +19,8 @@ const (
 	testSize = 5
 )
 
-type MockStore struct {
-	id *int64
-	mu *sync.Mutex
-}
-
-// Range 获取数据，传入数据中心ID，业务唯一ID和获取连续的区间大小，得到连续的最大ID和错误[id-size, id)
-func (p MockStore) Range(_ context.Context, dataCenter uint8, db, table string, size int64) (id int64, err error) {
-	p.mu.Lock()
-	id = *p.id
-	*p.id += size
-	p.mu.Unlock()
-	return id, err
-}
-
-// Ping 检查连接
-func (p MockStore) Ping(ctx context.Context) error {
-	return nil
-}
-
-// Init 这里可以完成初始化方法，保证服务的可用
-func (p MockStore) Init(dataCenter uint8) error { return nil }
-
-func (p MockStore) BlockDB(dataCenter uint8, db string) bool { return false }
-
 func TestService_Next(t *testing.T) {
-	db := MockStore{
-		id: new(int64),
-		mu: new(sync.Mutex),
-	}
+	db := store.NewMemory()
 	logger := logrus.NewEntry(logrus.New())
 	svc := New(logger, db, testSize, 0, 60, 600)
 	id, errMsg := svc.Next(context.TODO(), testDB, "next")
@@ -53,11 +30,22 @@ func TestService_Next(t *testing.T) {
 
 }
 
-func TestService_Last(t *testing.T) {
-	db := MockStore{
-		id: new(int64),
-		mu: new(sync.Mutex),
+func TestService_NextN(t *testing.T) {
+	db := store.NewMemory()
+	logger := logrus.NewEntry(logrus.New())
+	svc := New(logger, db, testSize, 0, 60, 600)
+	ids, errMsg := svc.NextN(context.TODO(), testDB, "nextn", testSize*2)
+	assert.Equal(t, errMsg, "")
+	assert.Equal(t, len(ids), testSize*2)
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Fatalf("ids not increase: %v", ids)
+		}
 	}
+}
+
+func TestService_Last(t *testing.T) {
+	db := store.NewMemory()
 	logger := logrus.NewEntry(logrus.New())
 	svc := New(logger, db, testSize, 0, 60, 600)
 
@@ -74,10 +62,7 @@ func TestService_Last(t *testing.T) {
 }
 
 func TestService_Remainder(t *testing.T) {
-	db := MockStore{
-		id: new(int64),
-		mu: new(sync.Mutex),
-	}
+	db := store.NewMemory()
 	logger := logrus.NewEntry(logrus.New())
 	svc := New(logger, db, testSize, 0, 60, 600)
 
@@ -92,11 +77,24 @@ func TestService_Remainder(t *testing.T) {
 	assert.Equal(t, remainder, int64(testSize-1))
 }
 
+func TestService_Decode(t *testing.T) {
+	db := store.NewMemory()
+	logger := logrus.NewEntry(logrus.New())
+	svc := New(logger, db, testSize, 1, 60, 600)
+
+	id, errMsg := svc.Next(context.TODO(), testDB, "decode")
+	assert.Equal(t, errMsg, "")
+	d, errMsg := svc.Decode(context.TODO(), id, generator.KindSegment)
+	assert.Equal(t, errMsg, "")
+	assert.Equal(t, d.DataCenter, uint8(1))
+	assert.Equal(t, d.Sequence, int64(1))
+
+	_, errMsg = svc.Decode(context.TODO(), -1, generator.KindSegment)
+	assert.Equal(t, errMsg, generator.ErrInvalidID.Error())
+}
+
 func BenchmarkService_Next(b *testing.B) {
-	db := MockStore{
-		id: new(int64),
-		mu: new(sync.Mutex),
-	}
+	db := store.NewMemory()
 	logger := logrus.NewEntry(logrus.New())
 	svc := New(logger, db, 1000, 0, 60, 600)
 	ctx := context.TODO()
@@ -105,3 +103,148 @@ func BenchmarkService_Next(b *testing.B) {
 		svc.Next(ctx, testDB, "bench")
 	}
 }
+
+func TestService_Migrate(t *testing.T) {
+	from := store.NewMemory()
+	logger := logrus.NewEntry(logrus.New())
+	svc := New(logger, from, testSize, 0, 60, 600)
+
+	table := "migrate"
+	last, errMsg := svc.Next(context.TODO(), testDB, table)
+	assert.Equal(t, errMsg, "")
+	// 旧存储中存在但是没有加载的table也需要迁移
+	_, err := from.Range(context.TODO(), 0, testDB, "unload", 100)
+	assert.Nil(t, err)
+	max, _ := svc.Max(context.TODO(), testDB, table)
+
+	to := store.NewMemory()
+	const margin = 1000
+	assert.Nil(t, svc.Migrate(context.TODO(), to, margin))
+	assert.Equal(t, svc.Store(), store.Store(to))
+	status := svc.Migration()
+	assert.Equal(t, status.State, MigrationDone)
+	assert.Equal(t, status.Tables, 2)
+	assert.Equal(t, status.Migrated, 2)
+
+	n, err := to.GetCounter(context.TODO(), 0, testDB, table)
+	assert.Nil(t, err)
+	assert.Equal(t, n, max+margin)
+	n, err = to.GetCounter(context.TODO(), 0, testDB, "unload")
+	assert.Nil(t, err)
+	assert.Equal(t, n, int64(100+margin))
+
+	// 切换之后继续发号，不会重复
+	ids, errMsg := svc.NextN(context.TODO(), testDB, table, testSize*3)
+	assert.Equal(t, errMsg, "")
+	for _, id := range ids {
+		if id <= last {
+			t.Fatalf("ids not increase: %d after %d", id, last)
+		}
+		last = id
+	}
+}
+
+// closeStore 记录是否被关闭，release 不为nil时Bump在release关闭之前阻塞
+type closeStore struct {
+	*store.Memory
+	closed  int32
+	release chan struct{}
+}
+
+func (p *closeStore) Close() error {
+	atomic.StoreInt32(&p.closed, 1)
+	return nil
+}
+
+func (p *closeStore) Bump(ctx context.Context, dataCenter uint8, db, table string, min int64) error {
+	if p.release != nil {
+		<-p.release
+	}
+	return p.Memory.Bump(ctx, dataCenter, db, table, min)
+}
+
+func TestService_MigrateUnblocked(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	from := &closeStore{Memory: store.NewMemory()}
+	svc := New(logger, from, testSize, 0, 60, 600)
+	_, errMsg := svc.Next(context.TODO(), testDB, "migrate")
+	assert.Equal(t, errMsg, "")
+
+	// 切换期间Store不阻塞，仍然返回旧的存储
+	to := &closeStore{Memory: store.NewMemory(), release: make(chan struct{})}
+	done := make(chan error)
+	go func() { done <- svc.Migrate(context.TODO(), to, 0) }()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, svc.Migration().State, MigrationRunning)
+	assert.Equal(t, svc.Store(), store.Store(from))
+	// 同时只能有一个切换，拒绝的存储被关闭
+	other := &closeStore{Memory: store.NewMemory()}
+	assert.Equal(t, svc.Migrate(context.TODO(), other, 0), ErrMigrationRunning)
+	assert.Equal(t, atomic.LoadInt32(&other.closed), int32(1))
+
+	close(to.release)
+	assert.NoError(t, <-done)
+	assert.Equal(t, svc.Store(), store.Store(to))
+	assert.Equal(t, atomic.LoadInt32(&from.closed), int32(1))
+	assert.Equal(t, atomic.LoadInt32(&to.closed), int32(0))
+}
+
+func TestService_MigrateFailed(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	from := &closeStore{Memory: store.NewMemory()}
+	svc := New(logger, from, testSize, 0, 60, 600)
+	_, errMsg := svc.Next(context.TODO(), testDB, "migrate")
+	assert.Equal(t, errMsg, "")
+
+	// 失败时继续使用旧的存储，关闭新的存储
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	to := &closeStore{Memory: store.NewMemory()}
+	assert.Error(t, svc.Migrate(ctx, to, 0))
+	assert.Equal(t, svc.Migration().State, MigrationFailed)
+	assert.Equal(t, svc.Store(), store.Store(from))
+	assert.Equal(t, atomic.LoadInt32(&from.closed), int32(0))
+	assert.Equal(t, atomic.LoadInt32(&to.closed), int32(1))
+
+	// 恢复加载
+	_, errMsg = svc.NextN(context.TODO(), testDB, "migrate", testSize*2)
+	assert.Equal(t, errMsg, "")
+}
+
+// noAdminStore 不支持store.Admin的存储
+type noAdminStore struct {
+	store.Store
+}
+
+func TestService_MigrateNoAdmin(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	from := store.NewMemory()
+	svc := New(logger, noAdminStore{from}, testSize, 0, 60, 600, WithEvictTTL(100*time.Millisecond))
+	_, errMsg := svc.Next(context.TODO(), testDB, "evicted")
+	assert.Equal(t, errMsg, "")
+	time.Sleep(300 * time.Millisecond)
+
+	// 淘汰的table只在旧存储中，读不到计数时拒绝切换
+	to := &closeStore{Memory: store.NewMemory()}
+	assert.Equal(t, svc.Migrate(context.TODO(), to, 0), ErrMigrationNoAdmin)
+	assert.Equal(t, svc.Migration().State, MigrationFailed)
+	assert.Equal(t, svc.Store(), store.Store(noAdminStore{from}))
+	assert.Equal(t, atomic.LoadInt32(&to.closed), int32(1))
+	_, err := to.GetCounter(context.TODO(), 0, testDB, "evicted")
+	assert.Equal(t, err, store.ErrTableNotExists)
+}
+
+func TestService_Stats(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	svc := New(logger, store.NewMemory(), testSize, 0, 60, 600)
+	_, errMsg := svc.Stats(context.TODO(), testDB, "stats")
+	assert.Equal(t, errMsg, ErrEmpty.Error())
+
+	_, errMsg = svc.Next(context.TODO(), testDB, "stats")
+	assert.Equal(t, errMsg, "")
+	stats, errMsg := svc.Stats(context.TODO(), testDB, "stats")
+	assert.Equal(t, errMsg, "")
+	assert.Equal(t, stats.Step, int64(testSize))
+	assert.Equal(t, stats.Remainder, int64(testSize-1))
+	assert.True(t, stats.Rate >= 0)
+}
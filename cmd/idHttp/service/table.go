@@ -0,0 +1,119 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/luw2007/rabbitid/generator"
+)
+
+// defaultMaxStepMultiplier 每次加载的数量最多为step的倍数
+const defaultMaxStepMultiplier = 1024
+
+// ErrIDLimit table的计数已经达到MaxID，不再发号
+var ErrIDLimit = errors.New("id limit reached")
+
+// A TableConfig 单个table的配置，DB 和 Table 支持path.Match的通配符，例如 "ugc"、"topic_*"、"*"。
+// 零值的字段使用服务的全局配置
+type TableConfig struct {
+	DB    string
+	Table string
+	// Step 初始每次加载的数量
+	Step int64
+	// MinBufferTime, MaxBufferTime 缓存最短和最长支持时间，用来调整每次加载的数量
+	MinBufferTime time.Duration
+	MaxBufferTime time.Duration
+	// MaxStepMultiplier 每次加载的数量最多为Step的倍数，默认1024
+	MaxStepMultiplier int64
+	// Kind 发号器类型，generator.KindSegment 或者 generator.KindSnowflake，默认顺序发号
+	Kind string
+	// MaxID 计数的上限，不包含机房位，只对顺序发号有效，0 表示不限制
+	MaxID int64
+	// RingSize, ExpandSize, RefillRatio 顺序发号缓存的号段数量和加载时机，0 使用generator的默认值，
+	// 见generator.WithRingSize、generator.WithExpandSize 和 generator.WithRefillRatio
+	RingSize    int
+	ExpandSize  int
+	RefillRatio float64
+}
+
+// Validate 检查通配符和发号器类型
+func (c TableConfig) Validate() error {
+	for _, pattern := range []string{c.DB, c.Table} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("table %s|%s: %v", c.DB, c.Table, err)
+		}
+	}
+	switch c.Kind {
+	case "", generator.KindSegment, generator.KindSnowflake:
+	default:
+		return fmt.Errorf("table %s|%s: unknown kind %q", c.DB, c.Table, c.Kind)
+	}
+	if c.Step < 0 || c.MaxStepMultiplier < 0 || c.MaxID < 0 {
+		return fmt.Errorf("table %s|%s: negative step, multiplier or max id", c.DB, c.Table)
+	}
+	if c.RingSize < 0 || c.ExpandSize < 0 || c.RefillRatio < 0 || c.RefillRatio > 1 {
+		return fmt.Errorf("table %s|%s: negative ring or expand size, or refill ratio out of [0, 1]", c.DB, c.Table)
+	}
+	return nil
+}
+
+// match db和table都匹配，空的DB或者Table匹配所有
+func (c TableConfig) match(db, table string) bool {
+	return matchPattern(c.DB, db) && matchPattern(c.Table, table)
+}
+
+func matchPattern(pattern, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// tableConfig 按照配置的顺序使用第一个匹配的配置，没有配置的字段使用全局配置
+func (p *service) tableConfig(db, table string) TableConfig {
+	c := TableConfig{DB: db, Table: table}
+	for _, t := range p.tables {
+		if t.match(db, table) {
+			c = t
+			c.DB, c.Table = db, table
+			break
+		}
+	}
+	if c.Step == 0 {
+		c.Step = p.Step
+	}
+	if c.MinBufferTime == 0 {
+		c.MinBufferTime = p.minBufferTime
+	}
+	if c.MaxBufferTime == 0 {
+		c.MaxBufferTime = p.maxBufferTime
+	}
+	if c.MaxStepMultiplier == 0 {
+		c.MaxStepMultiplier = defaultMaxStepMultiplier
+	}
+	if c.Kind == "" {
+		c.Kind = generator.KindSegment
+	}
+	return c
+}
+
+// newGenerator 按照table的配置生成发号器
+func (p *service) newGenerator(c TableConfig) generator.Generator {
+	if c.Kind == generator.KindSnowflake {
+		return generator.NewSnowflake(p.DataCenter, p.worker, c.DB, c.Table, generator.WithLayout(p.layout))
+	}
+	opts := []generator.Option{generator.WithLayout(p.layout)}
+	if c.RingSize > 0 {
+		opts = append(opts, generator.WithRingSize(c.RingSize))
+	}
+	if c.ExpandSize > 0 {
+		opts = append(opts, generator.WithExpandSize(c.ExpandSize))
+	}
+	if c.RefillRatio > 0 {
+		opts = append(opts, generator.WithRefillRatio(c.RefillRatio))
+	}
+	return generator.NewSegment(p.DataCenter, c.DB, c.Table, c.Step, opts...)
+}
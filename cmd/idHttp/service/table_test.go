@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/luw2007/rabbitid/generator"
+	"github.com/luw2007/rabbitid/store"
+)
+
+func TestService_TableConfig(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	svc := New(logger, store.NewMemory(), testSize, 0, 60, 600, WithTables(
+		TableConfig{DB: testDB, Table: "hot_*", Step: 100, MaxStepMultiplier: 8},
+		TableConfig{DB: testDB, Table: "hot_*", Step: 1},
+		TableConfig{Table: "time", Kind: generator.KindSnowflake},
+	)).(*service)
+
+	c := svc.tableConfig(testDB, "hot_topic")
+	assert.Equal(t, c.Step, int64(100))
+	assert.Equal(t, c.MaxStepMultiplier, int64(8))
+	assert.Equal(t, c.MinBufferTime, time.Duration(60))
+	c = svc.tableConfig(testDB, "cold")
+	assert.Equal(t, c.Step, int64(testSize))
+	assert.Equal(t, c.MaxStepMultiplier, int64(defaultMaxStepMultiplier))
+	assert.Equal(t, c.Kind, generator.KindSegment)
+
+	// 每个table使用自己的step
+	_, errMsg := svc.Next(context.TODO(), testDB, "hot_topic")
+	assert.Equal(t, errMsg, "")
+	remainder, _ := svc.Remainder(context.TODO(), testDB, "hot_topic")
+	assert.Equal(t, remainder, int64(99))
+
+	// 时间戳发号不使用存储
+	id, errMsg := svc.Next(context.TODO(), "other", "time")
+	assert.Equal(t, errMsg, "")
+	d, _ := svc.Decode(context.TODO(), id, generator.KindSnowflake)
+	assert.True(t, d.Timestamp > 0)
+	_, err := svc.Store().(*store.Memory).GetCounter(context.TODO(), 0, "other", "time")
+	assert.Equal(t, err, store.ErrTableNotExists)
+
+	assert.Error(t, TableConfig{Table: "["}.Validate())
+	assert.Error(t, TableConfig{Kind: "uuid"}.Validate())
+	assert.Error(t, TableConfig{RefillRatio: 2}.Validate())
+
+	// 缓存的号段数量和加载时机传给Segment
+	g := svc.newGenerator(TableConfig{DB: testDB, Table: "ring", Step: 10, ExpandSize: 1, RefillRatio: 1})
+	assert.NoError(t, g.Expand(0, 10))
+	assert.False(t, g.NeedExpand())
+}
+
+func TestService_NewSize(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	svc := New(logger, store.NewMemory(), testSize, 0, time.Second, 2*time.Second).(*service)
+	c := TableConfig{Step: testSize, MinBufferTime: time.Second, MaxBufferTime: 2 * time.Second, MaxStepMultiplier: 4}
+
+	// 还没有速度时使用Step，之后加载MaxBufferTime内预计发出的数量，不超过MaxStepMultiplier倍
+	e := newEntry(generator.NewSegment(0, testDB, "size", testSize))
+	for _, tt := range []struct {
+		rate float64
+		want int64
+	}{{0, testSize}, {1, testSize}, {7, 14}, {100, testSize * 4}} {
+		e.rate = tt.rate
+		assert.Equal(t, svc.newSize(e, c), tt.want)
+	}
+}
+
+func TestService_NeedExpand(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	svc := New(logger, store.NewMemory(), testSize, 0, time.Second, 2*time.Second).(*service)
+	c := TableConfig{Step: testSize, MinBufferTime: time.Second, MaxBufferTime: 2 * time.Second, MaxStepMultiplier: 4}
+
+	e := newEntry(generator.NewSegment(0, testDB, "need", testSize))
+	assert.NoError(t, e.Expand(0, 10))
+	assert.NoError(t, e.Expand(10, 10))
+	assert.NoError(t, e.Expand(20, 10))
+	// 没有速度时使用发号器的判断，缓存已经有3段
+	assert.False(t, svc.needExpand(e, c))
+	// 剩余30个，按照速度不够1秒时加载
+	e.rate = 40
+	assert.True(t, svc.needExpand(e, c))
+	e.rate = 20
+	assert.False(t, svc.needExpand(e, c))
+	// 时间戳发号不加载
+	c.Kind = generator.KindSnowflake
+	e.rate = 40
+	assert.False(t, svc.needExpand(e, c))
+}
+
+func TestService_NeedExpandRefillRatio(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	svc := New(logger, store.NewMemory(), testSize, 0, time.Second, 2*time.Second, WithTables(
+		TableConfig{Table: "ratio", Step: 100, ExpandSize: 2, RefillRatio: 0.5},
+	)).(*service)
+	_, errMsg := svc.Next(context.TODO(), testDB, "ratio")
+	assert.Equal(t, errMsg, "")
+	gs, ok := svc.Generator.Load(testDB + "|ratio")
+	assert.True(t, ok)
+	e := gs.(*entry)
+	c := svc.tableConfig(testDB, "ratio")
+
+	// 有速度之后，余量足够MinBufferTime时仍然按照RefillRatio加载
+	e.rate = 1
+	assert.False(t, svc.needExpand(e, c))
+	_, errMsg = svc.NextN(context.TODO(), testDB, "ratio", 60)
+	assert.Equal(t, errMsg, "")
+	assert.True(t, svc.needExpand(e, c))
+}
+
+func TestEntry_Sample(t *testing.T) {
+	e := newEntry(generator.NewSegment(0, testDB, "rate", testSize))
+	now := e.sampledAt
+	// 每秒100个，经过几个平滑时间后接近100
+	for i := 0; i < 50; i++ {
+		e.issue(100)
+		now = now.Add(time.Second)
+		e.sample(now)
+	}
+	assert.InDelta(t, e.Rate(), 100, 1)
+	// 停止发号后速度下降
+	now = now.Add(rateWindow)
+	e.sample(now)
+	assert.True(t, e.Rate() < 50)
+}
+
+func TestService_MaxID(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	svc := New(logger, store.NewMemory(), testSize, 0, 60, 600, WithTables(
+		TableConfig{Table: "limit", MaxID: 7},
+	))
+
+	ids, errMsg := svc.NextN(context.TODO(), testDB, "limit", 20)
+	assert.Equal(t, errMsg, "")
+	assert.Equal(t, ids, []int64{1, 2, 3, 4, 5, 6, 7})
+	_, errMsg = svc.Next(context.TODO(), testDB, "limit")
+	assert.Equal(t, errMsg, ErrIDLimit.Error())
+}
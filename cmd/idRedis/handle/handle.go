@@ -1,11 +1,14 @@
 package handle
 
 import (
+	"crypto/subtle"
 	"fmt"
+	"log"
 	"runtime"
 	"sync"
 
 	"context"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,19 +17,28 @@ import (
 
 	"github.com/luw2007/rabbitid/cmd/idHttp/conf"
 	"github.com/luw2007/rabbitid/cmd/idHttp/service"
+	"github.com/luw2007/rabbitid/generator"
 	"github.com/luw2007/rabbitid/store"
 )
 
 type Handler struct {
-	svc    service.Service
-	db     store.Store
-	logger *logrus.Entry
+	svc service.Service
+	db  store.Store
+	dc  uint8
+	// adminToken ADMIN AUTH 的token，为空时不开启ADMIN
+	adminToken string
+	logger     *logrus.Entry
 }
 
+// adminAuthorized 连接通过ADMIN AUTH 认证后的context
+type adminAuthorized struct{}
+
 const (
 	slowTime      = 20 * time.Millisecond
 	cancelTimeout = 500 * time.Millisecond
 	defautDB      = "_NO_APP_"
+	// maxCount 批量获取ID的最大数量
+	maxCount = 10000
 )
 
 var (
@@ -101,6 +113,100 @@ func (p *Handler) Serve(conn redcon.Conn, cmd redcon.Command) {
 			return
 		}
 		conn.WriteInt64(id)
+	case "nextn":
+		var (
+			db    string
+			table string
+			count []byte
+		)
+		switch len(cmd.Args) {
+		default:
+			conn.WriteError("ERR wrong number of arguments for '" + name + "' command.")
+			return
+		case 3:
+			names := strings.SplitN(string(cmd.Args[1]), ":", 2)
+			if len(names) > 1 {
+				db, table = names[0], names[1]
+			} else {
+				db, table = defautDB, names[0]
+			}
+			count = cmd.Args[2]
+		case 4:
+			db = string(cmd.Args[1])
+			table = string(cmd.Args[2])
+			count = cmd.Args[3]
+		}
+		n, err := strconv.ParseInt(string(count), 10, 64)
+		if err != nil || n < 1 || n > maxCount {
+			conn.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+		defer cancel()
+		ids, msg := p.svc.NextN(ctx, db, table, n)
+		if msg != "" {
+			conn.WriteError(msg)
+			return
+		}
+		conn.WriteArray(len(ids))
+		for _, id := range ids {
+			conn.WriteInt64(id)
+		}
+	case "decode":
+		if len(cmd.Args) != 2 && len(cmd.Args) != 3 {
+			conn.WriteError("ERR wrong number of arguments for '" + name + "' command.")
+			return
+		}
+		id, err := strconv.ParseInt(string(cmd.Args[1]), 10, 64)
+		if err != nil {
+			conn.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+		kind := generator.KindSegment
+		if len(cmd.Args) == 3 {
+			kind = strings.ToLower(string(cmd.Args[2]))
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+		defer cancel()
+		d, msg := p.svc.Decode(ctx, id, kind)
+		if msg != "" {
+			conn.WriteError(msg)
+			return
+		}
+		// 和HGETALL一样返回字段和值
+		conn.WriteArray(8)
+		conn.WriteBulkString("dc")
+		conn.WriteInt(int(d.DataCenter))
+		conn.WriteBulkString("sequence")
+		conn.WriteInt64(d.Sequence)
+		conn.WriteBulkString("worker")
+		conn.WriteInt64(d.Worker)
+		conn.WriteBulkString("timestamp")
+		conn.WriteInt64(d.Timestamp)
+	case "stats":
+		if len(cmd.Args) != 3 {
+			conn.WriteError("ERR wrong number of arguments for '" + name + "' command.")
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+		defer cancel()
+		stats, msg := p.svc.Stats(ctx, string(cmd.Args[1]), string(cmd.Args[2]))
+		if msg != "" {
+			conn.WriteError(msg)
+			return
+		}
+		// 和HGETALL一样返回字段和值，速度等浮点数使用字符串
+		conn.WriteArray(10)
+		conn.WriteBulkString("step")
+		conn.WriteInt64(stats.Step)
+		conn.WriteBulkString("rate")
+		conn.WriteBulkString(strconv.FormatFloat(stats.Rate, 'f', 2, 64))
+		conn.WriteBulkString("remainder")
+		conn.WriteInt64(stats.Remainder)
+		conn.WriteBulkString("max")
+		conn.WriteInt64(stats.Max)
+		conn.WriteBulkString("idle")
+		conn.WriteBulkString(strconv.FormatFloat(stats.Idle, 'f', 3, 64))
 	case "check":
 		ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
 		defer cancel()
@@ -110,14 +216,20 @@ func (p *Handler) Serve(conn redcon.Conn, cmd redcon.Command) {
 		}
 		conn.WriteString("OK")
 
+	case "admin":
+		p.admin(conn, cmd)
 	case "ping":
 		conn.WriteString("PONG")
 	case "help":
 		conn.WriteString(`usage: [cmd] DB TABLE
 		next
+		nextn DB TABLE COUNT
+		decode ID [segment|snowflake]
+		stats DB TABLE
 		last
 		max
 		remainder
+		admin AUTH TOKEN | CREATEDB DB | DBS | TABLES DB | COUNTER DB TABLE | DELTABLE DB TABLE | PENDING | APPROVE DB TABLE
 	`)
 	case "quit":
 		conn.WriteString("OK")
@@ -126,6 +238,106 @@ func (p *Handler) Serve(conn redcon.Conn, cmd redcon.Command) {
 		p.ShutDown()
 	}
 }
+
+// admin 管理存储中的db和table，子命令和参数个数：
+// AUTH TOKEN、CREATEDB DB、DBS、TABLES DB、COUNTER DB TABLE、DELTABLE DB TABLE、PENDING、APPROVE DB TABLE。
+// 没有配置token时不开启，连接需要先通过AUTH认证
+func (p *Handler) admin(conn redcon.Conn, cmd redcon.Command) {
+	args := map[string]int{"auth": 1, "createdb": 1, "dbs": 0, "tables": 1, "counter": 2, "deltable": 2, "approve": 2, "pending": 0}
+	if p.adminToken == "" {
+		conn.WriteError("ERR admin is disabled")
+		return
+	}
+	if len(cmd.Args) < 2 {
+		p.usage(conn, "admin")
+		return
+	}
+	sub := strings.ToLower(string(cmd.Args[1]))
+	n, ok := args[sub]
+	if !ok {
+		conn.WriteError("ERR unknown subcommand '" + sub + "'")
+		return
+	}
+	if len(cmd.Args) != n+2 {
+		p.usage(conn, "admin "+sub)
+		return
+	}
+	if sub == "auth" {
+		if subtle.ConstantTimeCompare(cmd.Args[2], []byte(p.adminToken)) != 1 {
+			conn.SetContext(nil)
+			conn.WriteError("ERR invalid admin token")
+			return
+		}
+		conn.SetContext(adminAuthorized{})
+		conn.WriteString("OK")
+		return
+	}
+	if _, ok := conn.Context().(adminAuthorized); !ok {
+		conn.WriteError("NOAUTH admin authentication required")
+		return
+	}
+	// 审批不需要存储支持管理接口
+	switch sub {
+	case "approve":
+		ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+		defer cancel()
+		if err := p.svc.Approve(ctx, string(cmd.Args[2]), string(cmd.Args[3])); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		conn.WriteString("OK")
+		return
+	case "pending":
+		// 每一项为 db、table、请求次数
+		pending := p.svc.Pending()
+		conn.WriteArray(len(pending))
+		for _, t := range pending {
+			conn.WriteArray(3)
+			conn.WriteBulkString(t.DB)
+			conn.WriteBulkString(t.Table)
+			conn.WriteInt64(t.Requests)
+		}
+		return
+	}
+	admin, err := store.AdminOf(p.db)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+	defer cancel()
+	var names []string
+	switch sub {
+	case "createdb":
+		err = admin.CreateDB(ctx, p.dc, string(cmd.Args[2]))
+	case "dbs":
+		names, err = admin.ListDBs(ctx, p.dc)
+	case "tables":
+		names, err = admin.ListTables(ctx, p.dc, string(cmd.Args[2]))
+	case "counter":
+		var max int64
+		if max, err = admin.GetCounter(ctx, p.dc, string(cmd.Args[2]), string(cmd.Args[3])); err == nil {
+			conn.WriteInt64(max)
+			return
+		}
+	case "deltable":
+		// 发号器还在使用时拒绝删除
+		err = p.svc.DeleteTable(ctx, string(cmd.Args[2]), string(cmd.Args[3]))
+	}
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	if sub == "dbs" || sub == "tables" {
+		conn.WriteArray(len(names))
+		for _, name := range names {
+			conn.WriteBulkString(name)
+		}
+		return
+	}
+	conn.WriteString("OK")
+}
+
 func (p *Handler) usage(conn redcon.Conn, name string) {
 	conn.WriteError("ERR wrong number of arguments for '" + name + "' command.")
 
@@ -153,7 +365,18 @@ func (p *Handler) ShutDown() {
 
 func NewRedisHandler(config conf.Config) *Handler {
 	logger := config.Logger.WithField("app", "redis")
-	db := store.NewStore(config.Store.Type, config.Store.URI, config.Generate.DataCenter, logger)
-	svc := service.New(logger, db, config.Generate.Step, config.Generate.DataCenter, config.Store.Min, config.Store.Max)
-	return &Handler{svc: svc, db: db, logger: logger}
+	db, err := store.NewStore(config.Store.Type, config.Store.URI, config.Generate.DataCenter, logger, config.StoreOptions()...)
+	if err != nil {
+		log.Fatalln("store init error", err.Error())
+	}
+	if config.Store.Watermark != "" {
+		wm, err := store.NewWatermark(db, config.Store.Watermark, logger)
+		if err != nil {
+			logger.WithError(err).Fatal("watermark init error")
+		}
+		db = wm
+	}
+	svc := service.New(logger, db, config.Generate.Step, config.Generate.DataCenter, config.Store.Min, config.Store.Max,
+		config.ServiceOptions()...)
+	return &Handler{svc: svc, db: db, dc: config.Generate.DataCenter, adminToken: config.Server.AdminToken, logger: logger}
 }
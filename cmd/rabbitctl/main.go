@@ -0,0 +1,217 @@
+// rabbitctl 导出、导入和比较存储中的计数，用于离线切换存储和备份恢复
+//
+//	rabbitctl export -store redis -uri 127.0.0.1:6379 -dc 0 -format json -o counters.json
+//	rabbitctl import -store zk -uri 127.0.0.1:2181 -margin 10000 -i counters.json
+//	rabbitctl diff -store redis -uri 127.0.0.1:6379 -to zk -to.uri 127.0.0.1:2181 -dc 0
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/luw2007/rabbitid/store"
+)
+
+const usage = `usage: rabbitctl <command> [flags]
+
+commands:
+  export  导出机房下所有table的计数到快照
+  import  把快照导入存储，只增不减，可以加上margin
+  diff    比较两个存储中的计数
+
+rabbitctl <command> -h 查看每个命令的参数
+`
+
+// storeFlags 连接存储的参数
+type storeFlags struct {
+	storeType, uri string
+	user, password string
+	// prefix 参数前缀，diff 的目标存储使用 -to -to.uri
+	prefix string
+}
+
+func (p *storeFlags) register(fs *flag.FlagSet, prefix string) {
+	p.prefix = prefix
+	name := "store"
+	if prefix != "" {
+		name = prefix
+		prefix += "."
+	}
+	fs.StringVar(&p.storeType, name, "", "store type: "+strings.Join(store.Types(), " ")+", empty for URI scheme")
+	fs.StringVar(&p.uri, prefix+"uri", "", "store URI, e.g. redis://127.0.0.1:6379")
+	fs.StringVar(&p.user, prefix+"user", "", "zk/etcd user")
+	fs.StringVar(&p.password, prefix+"password", "", "zk/etcd password")
+}
+
+// open 连接存储，参数错误时退出。init 为false时不初始化，export和diff只读取计数，
+// 不能写入源存储，例如zk的升级标记、sql建表
+func (p *storeFlags) open(dataCenter uint8, init bool, logger *logrus.Entry) store.Store {
+	if p.storeType == "" && p.uri == "" {
+		fatal(fmt.Errorf("missing -%s", p.flagName()))
+	}
+	var opts []store.StoreOption
+	if !init {
+		opts = append(opts, store.WithoutInit())
+	}
+	if p.user != "" {
+		opts = append(opts,
+			store.WithZK(store.Credentials(p.user, p.password)),
+			store.WithEtcd(store.EtcdCredentials(p.user, p.password)))
+	}
+	s, err := store.NewStore(p.storeType, p.uri, dataCenter, logger, opts...)
+	if err != nil {
+		fatal(err)
+	}
+	return s
+}
+
+func (p *storeFlags) flagName() string {
+	if p.prefix == "" {
+		return "store"
+	}
+	return p.prefix
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	log := logrus.New()
+	log.Out = os.Stderr
+	log.SetLevel(logrus.WarnLevel)
+	logger := log.WithField("svc", "rabbitctl")
+
+	ctx := context.Background()
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "export":
+		export(ctx, args, logger)
+	case "import":
+		importSnapshot(ctx, args, logger)
+	case "diff":
+		diff(ctx, args, logger)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func export(ctx context.Context, args []string, logger *logrus.Entry) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var from storeFlags
+	from.register(fs, "")
+	dc := fs.Uint("dc", 0, "data center")
+	format := fs.String("format", store.SnapshotJSON, "snapshot format: json csv")
+	output := fs.String("o", "-", "output file, - for stdout")
+	fs.Parse(args)
+
+	s := from.open(uint8(*dc), false, logger)
+	counters, err := store.Export(ctx, s, uint8(*dc))
+	if err != nil {
+		fatal(err)
+	}
+	w, closeFn := create(*output)
+	if err = store.WriteSnapshot(w, *format, counters); err != nil {
+		fatal(err)
+	}
+	if err = closeFn(); err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d counters\n", len(counters))
+}
+
+func importSnapshot(ctx context.Context, args []string, logger *logrus.Entry) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	var to storeFlags
+	to.register(fs, "")
+	format := fs.String("format", store.SnapshotJSON, "snapshot format: json csv")
+	input := fs.String("i", "-", "input file, - for stdin")
+	margin := fs.Int64("margin", 0, "add margin to every counter")
+	fs.Parse(args)
+	if *margin < 0 {
+		fatal(fmt.Errorf("margin must not be negative: %d", *margin))
+	}
+
+	r := os.Stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		r = f
+	}
+	counters, err := store.ReadSnapshot(r, *format)
+	if err != nil {
+		fatal(err)
+	}
+	// 快照可以包含多个机房，NewStore 只初始化第一个机房，其他机房分别初始化
+	var dc uint8
+	if len(counters) > 0 {
+		dc = counters[0].DataCenter
+	}
+	s := to.open(dc, true, logger)
+	inited := map[uint8]bool{dc: true}
+	for _, c := range counters {
+		if !inited[c.DataCenter] {
+			if err = s.Init(c.DataCenter); err != nil {
+				fatal(err)
+			}
+			inited[c.DataCenter] = true
+		}
+	}
+	result, err := store.Import(ctx, s, counters, *margin)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "raised %d counters, skipped %d\n", result.Raised, result.Skipped)
+}
+
+func diff(ctx context.Context, args []string, logger *logrus.Entry) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	var from, to storeFlags
+	from.register(fs, "")
+	to.register(fs, "to")
+	dc := fs.Uint("dc", 0, "data center")
+	fs.Parse(args)
+
+	diffs, err := store.Diff(ctx, from.open(uint8(*dc), false, logger), to.open(uint8(*dc), false, logger), uint8(*dc))
+	if err != nil {
+		fatal(err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, d := range diffs {
+		if err = enc.Encode(d); err != nil {
+			fatal(err)
+		}
+	}
+	// 有差异时返回1，方便在脚本中判断
+	if len(diffs) > 0 {
+		os.Exit(1)
+	}
+}
+
+// create 打开输出文件，- 表示标准输出
+func create(name string) (io.Writer, func() error) {
+	if name == "-" {
+		return os.Stdout, func() error { return nil }
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		fatal(err)
+	}
+	return f, f.Close
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "rabbitctl:", err)
+	os.Exit(1)
+}
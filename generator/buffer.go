@@ -6,11 +6,20 @@ import (
 )
 
 // A Buffer 存储分配ID (offset, max]
-// 通过设置disabled为true，表示当前Buffer已经发完
+// 通过设置disabled为true，表示当前Buffer已经发完。
+// max 和 step 在交给Segment之前写入，之后只读；offset 和 disabled 使用atomic读写
 type Buffer struct {
 	disabled, max, offset, step int64
 }
 
+// newBuffer 新的号段 (min, min+step]，每次加载都使用新的Buffer，
+// 持有旧Buffer的goroutine不会读到新的号段
+func newBuffer(min, step int64) *Buffer {
+	b := new(Buffer)
+	setBuffer(min, step, b)
+	return b
+}
+
 // setBuffer 设置buffer，传入最小值min和大小size，返回Buffer对象
 // 这里可以分配的数字范围 (min, min+step]
 func setBuffer(min, step int64, buff *Buffer) {
@@ -53,6 +62,27 @@ func (b *Buffer) Next() (id int64, isDisabled bool, err error) {
 	return id, id >= b.max, nil
 }
 
+// NextN 批量获取号码，返回可用范围 (min, min+count]，isDisabled 是否可用，err 错误。
+// 通过一次atomic.AddInt64占用n个号码，超出b.max的部分丢弃，所以count可能小于n。
+// 和Next一样，只有一个goroutine获取到的范围包含b.max，isDisabled=true。
+func (b *Buffer) NextN(n int64) (min, count int64, isDisabled bool, err error) {
+	if b.IsDisabled() {
+		return 0, 0, true, ErrEmpty
+	}
+	end := atomic.AddInt64(&b.offset, n)
+	min = end - n
+	// 达到或者超出范围，当前buff都要设置为不可用
+	if end >= b.max {
+		b.SetDisabled()
+		// 并发下其他goroutine已经取到b.max
+		if min >= b.max {
+			return 0, 0, true, ErrEmpty
+		}
+		end = b.max
+	}
+	return min, end - min, end >= b.max, nil
+}
+
 // Last 最后一个值，并发下不精确
 func (b *Buffer) Last() int64 {
 	if b.IsDisabled() {
@@ -70,7 +100,15 @@ func (b *Buffer) Remainder() int64 {
 	return 0
 }
 
+// remaining 剩余数量占总数的比例，不可用时为0
+func (b *Buffer) remaining() float64 {
+	if b.IsDisabled() || b.step <= 0 {
+		return 0
+	}
+	return float64(b.Remainder()) / float64(b.step)
+}
+
 // String 打印出内部对象
-func (b Buffer) String() string {
-	return fmt.Sprintf("{max:%d, step:%d, offset:%d, disabled:%t}", b.max, b.step, b.offset, b.IsDisabled())
+func (b *Buffer) String() string {
+	return fmt.Sprintf("{max:%d, step:%d, offset:%d, disabled:%t}", b.max, b.step, atomic.LoadInt64(&b.offset), b.IsDisabled())
 }
@@ -30,6 +30,26 @@ func TestBuffer_Next(t *testing.T) {
 	assert.Error(t, err, ErrEmpty)
 }
 
+func TestBuffer_NextN(t *testing.T) {
+	b := new(Buffer)
+	setBuffer(0, 5, b)
+	min, count, isDisabled, err := b.NextN(3)
+	assert.NoError(t, err)
+	assert.Equal(t, min, int64(0))
+	assert.Equal(t, count, int64(3))
+	assert.Equal(t, isDisabled, false)
+
+	// 超出范围只返回剩余部分
+	min, count, isDisabled, err = b.NextN(3)
+	assert.NoError(t, err)
+	assert.Equal(t, min, int64(3))
+	assert.Equal(t, count, int64(2))
+	assert.Equal(t, isDisabled, true)
+
+	_, _, _, err = b.NextN(3)
+	assert.EqualError(t, err, ErrEmpty.Error())
+}
+
 func TestBuffer_Remainder(t *testing.T) {
 	var min, step int64
 	step = 2
@@ -0,0 +1,59 @@
+package generator
+
+import "errors"
+
+const (
+	// KindSegment 顺序发号
+	KindSegment = "segment"
+	// KindSnowflake 时间戳发号
+	KindSnowflake = "snowflake"
+)
+
+// ErrInvalidID ID不是按照当前位分布生成的，比如负数或者保留位不为0
+var ErrInvalidID = errors.New("invalid id")
+
+// A DecodedID 从ID中解析出的各部分
+type DecodedID struct {
+	ID         int64 `json:"id"`
+	DataCenter uint8 `json:"dc"`
+	// Sequence 去掉机房位后的计数，Segment发出的ID即为存储中的计数，分库分表使用该值；
+	// Snowflake发出的ID为毫秒内的序号
+	Sequence int64 `json:"sequence"`
+	// Worker, Timestamp 只有Snowflake发出的ID才有，Timestamp 为unix毫秒时间戳
+	Worker    int64 `json:"worker,omitempty"`
+	Timestamp int64 `json:"timestamp,omitempty"`
+}
+
+// Decode 使用默认位分布解析Segment发出的ID
+func Decode(id int64) (DecodedID, error) {
+	return DefaultLayout.Decode(id, KindSegment)
+}
+
+// Decode 按照位分布和发号器类型解析ID，使用和发号时相同的掩码
+func (l IDLayout) Decode(id int64, kind string) (DecodedID, error) {
+	shift := l.dataCenterShift()
+	if id < 0 || id>>shift > l.DataCenterMask() {
+		return DecodedID{}, ErrInvalidID
+	}
+	d := DecodedID{
+		ID:         id,
+		DataCenter: uint8(id >> shift & l.DataCenterMask()),
+	}
+	switch kind {
+	default:
+		return DecodedID{}, ErrInvalidID
+	case KindSegment, "":
+		d.Sequence = id & l.CounterMask()
+	case KindSnowflake:
+		d.Sequence = id & l.SequenceMask()
+		d.Worker = id >> l.SequenceBits & l.WorkerMask()
+		timestampMask := int64(-1 ^ (-1 << l.TimestampBits()))
+		d.Timestamp = id>>(l.WorkerBits+l.SequenceBits)&timestampMask + DefaultEpoch
+	}
+	return d, nil
+}
+
+// StripDataCenter 去掉机房位，用于分库分表
+func (l IDLayout) StripDataCenter(id int64) int64 {
+	return id & l.CounterMask()
+}
@@ -0,0 +1,47 @@
+package generator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	var testDC2 uint8 = 3
+	seg := NewSegment(testDC2, testDB, testTable, testSize)
+	seg.Expand(100, testSize)
+	id, err := seg.Next()
+	assert.NoError(t, err)
+
+	d, err := Decode(id)
+	assert.NoError(t, err)
+	assert.Equal(t, d.DataCenter, testDC2)
+	assert.Equal(t, d.Sequence, int64(101))
+	assert.Equal(t, DefaultLayout.StripDataCenter(id), int64(101))
+
+	_, err = Decode(-1)
+	assert.EqualError(t, err, ErrInvalidID.Error())
+}
+
+func TestIDLayout_Decode(t *testing.T) {
+	var testDC2 uint8 = 2
+	now := time.Now()
+	sf := NewSnowflake(testDC2, testWorker, testDB, testTable)
+	sf.now = func() time.Time { return now }
+	sf.Next()
+	id, err := sf.Next()
+	assert.NoError(t, err)
+
+	d, err := DefaultLayout.Decode(id, KindSnowflake)
+	assert.NoError(t, err)
+	assert.Equal(t, d.DataCenter, testDC2)
+	assert.Equal(t, d.Worker, int64(testWorker))
+	assert.Equal(t, d.Sequence, int64(1))
+	assert.Equal(t, d.Timestamp, now.UnixNano()/int64(time.Millisecond))
+
+	// 保留位不为0
+	l := IDLayout{SignBits: 11, DataCenterBits: 2, WorkerBits: 0, SequenceBits: 12}
+	_, err = l.Decode(int64(1)<<60, KindSegment)
+	assert.EqualError(t, err, ErrInvalidID.Error())
+}
@@ -1,4 +1,4 @@
-// Package generator 发号包，目前实现顺序发号Segment
+// Package generator 发号包，目前实现顺序发号Segment和时间戳发号Snowflake
 package generator
 
 import "time"
@@ -8,11 +8,16 @@ type Generator interface {
 	Expand(int64, int64) error
 	Last() int64
 	Len() int64
+	// Max 发号器的上限，含义取决于发号器：Segment 为从存储加载过的最大计数，
+	// Snowflake 为当前毫秒可以生成的最大ID，包含机房和时间戳。不同发号器的Max不能比较，
+	// 只有Segment的Max可以用于拉高存储的计数
 	Max() int64
 	DB() string
 	Table() string
 	NeedExpand() bool
 	Next() (int64, error)
+	// NextN 批量获取号码，返回的数量可能小于n
+	NextN(n int64) ([]int64, error)
 	Step() int64
 	UpdateTime() time.Time
 }
@@ -0,0 +1,115 @@
+package generator
+
+import "errors"
+
+// An IDLayout ID的位分布，从高位到低位依次为：符号位、机房位、时间戳位、worker位、序号位。
+// 时间戳位占用剩余的位数，只有Snowflake使用；Segment把机房位以下的所有位作为自增计数。
+type IDLayout struct {
+	// SignBits 最高位保留的位数，至少为1保证ID为正数，
+	// 设置为11可以让ID不超过2^53，方便javascript使用
+	SignBits uint `toml:"sign_bits"`
+	// DataCenterBits 机房位数
+	DataCenterBits uint `toml:"dc_bits"`
+	// WorkerBits Snowflake 进程位数
+	WorkerBits uint `toml:"worker_bits"`
+	// SequenceBits Snowflake 每毫秒序号位数
+	SequenceBits uint `toml:"sequence_bits"`
+}
+
+const (
+	// minTimestampBits 时间戳最少位数，39位毫秒大约可以使用17年
+	minTimestampBits = 39
+	// maxDataCenterBits 机房ID使用uint8，最多8位
+	maxDataCenterBits = 8
+)
+
+var (
+	// DefaultLayout 默认的位分布，和之前发出的ID保持一致
+	DefaultLayout = IDLayout{
+		SignBits:       1,
+		DataCenterBits: dataCenterBits,
+		WorkerBits:     workerBits,
+		SequenceBits:   snowflakeSequenceBits,
+	}
+
+	// ErrLayoutInvalid 位分布不合法
+	ErrLayoutInvalid = errors.New("invalid id layout")
+	// ErrLayoutDataCenter 机房ID超出机房位的范围
+	ErrLayoutDataCenter = errors.New("data center out of id layout")
+	// ErrLayoutIncompatible 新的位分布会和已经发出的ID冲突
+	ErrLayoutIncompatible = errors.New("id layout incompatible with issued ids")
+)
+
+// Validate 检查位分布是否合法
+func (l IDLayout) Validate() error {
+	if l.SignBits < 1 || l.DataCenterBits > maxDataCenterBits || l.SequenceBits < 1 {
+		return ErrLayoutInvalid
+	}
+	if l.SignBits+l.DataCenterBits+l.WorkerBits+l.SequenceBits+minTimestampBits > maxIntBits {
+		return ErrLayoutInvalid
+	}
+	return nil
+}
+
+// Compatible 检查切换到新的位分布后，机房dataCenter发出的ID不会和prev发出的ID冲突。
+// 存储中的计数按照机房ID保存，切换后计数会继续增长。所以机房在prev中已经存在时，
+// 编码后的机房前缀必须保持不变；并且新的号段范围不能和prev中其他机房的号段范围重叠。
+func (l IDLayout) Compatible(prev IDLayout, dataCenter uint8) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+	if int64(dataCenter) > l.DataCenterMask() {
+		return ErrLayoutDataCenter
+	}
+	prefix := l.DataCenterPrefix(dataCenter)
+	exists := int64(dataCenter) <= prev.DataCenterMask()
+	if exists && prefix != prev.DataCenterPrefix(dataCenter) {
+		return ErrLayoutIncompatible
+	}
+	// 号段范围 [prefix, prefix|CounterMask]
+	for dc := int64(0); dc <= prev.DataCenterMask(); dc++ {
+		if exists && dc == int64(dataCenter) {
+			continue
+		}
+		start := prev.DataCenterPrefix(uint8(dc))
+		if prefix <= start|prev.CounterMask() && start <= prefix|l.CounterMask() {
+			return ErrLayoutIncompatible
+		}
+	}
+	return nil
+}
+
+// DataCenterMask 机房最大数量
+func (l IDLayout) DataCenterMask() int64 {
+	return int64(-1 ^ (-1 << l.DataCenterBits))
+}
+
+// dataCenterShift 机房位的偏移，也是计数位的位数
+func (l IDLayout) dataCenterShift() uint {
+	return maxIntBits - l.SignBits - l.DataCenterBits
+}
+
+// DataCenterPrefix 编码后的机房位，使用"|"和计数合并成ID
+func (l IDLayout) DataCenterPrefix(dataCenter uint8) int64 {
+	return (int64(dataCenter) & l.DataCenterMask()) << l.dataCenterShift()
+}
+
+// CounterMask 机房位以下的计数位，Segment的最大递增数
+func (l IDLayout) CounterMask() int64 {
+	return int64(-1 ^ (-1 << l.dataCenterShift()))
+}
+
+// TimestampBits Snowflake时间戳占用的位数
+func (l IDLayout) TimestampBits() uint {
+	return l.dataCenterShift() - l.WorkerBits - l.SequenceBits
+}
+
+// WorkerMask Snowflake worker最大数量
+func (l IDLayout) WorkerMask() int64 {
+	return int64(-1 ^ (-1 << l.WorkerBits))
+}
+
+// SequenceMask Snowflake 每毫秒最大序号
+func (l IDLayout) SequenceMask() int64 {
+	return int64(-1 ^ (-1 << l.SequenceBits))
+}
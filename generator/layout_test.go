@@ -0,0 +1,66 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIDLayout_Validate(t *testing.T) {
+	assert.NoError(t, DefaultLayout.Validate())
+
+	// 没有符号位
+	l := DefaultLayout
+	l.SignBits = 0
+	assert.EqualError(t, l.Validate(), ErrLayoutInvalid.Error())
+
+	// 时间戳位数不足
+	l = DefaultLayout
+	l.SequenceBits = 20
+	assert.EqualError(t, l.Validate(), ErrLayoutInvalid.Error())
+
+	// javascript 安全整数
+	l = IDLayout{SignBits: 11, DataCenterBits: 2, WorkerBits: 0, SequenceBits: 12}
+	assert.NoError(t, l.Validate())
+}
+
+func TestIDLayout_Default(t *testing.T) {
+	assert.Equal(t, DefaultLayout.DataCenterMask(), DataCenterMask)
+	assert.Equal(t, DefaultLayout.DataCenterPrefix(1), int64(1)<<sequenceBits)
+	assert.Equal(t, DefaultLayout.TimestampBits(), uint(41))
+}
+
+func TestIDLayout_Compatible(t *testing.T) {
+	wide := DefaultLayout
+	wide.DataCenterBits = 5
+
+	assert.NoError(t, DefaultLayout.Compatible(DefaultLayout, 3))
+	// 机房0的前缀不变
+	assert.NoError(t, wide.Compatible(DefaultLayout, 0))
+	// 已有机房的前缀发生变化
+	assert.EqualError(t, wide.Compatible(DefaultLayout, 1), ErrLayoutIncompatible.Error())
+	// 新增机房和已有机房2的起点重合
+	assert.EqualError(t, wide.Compatible(DefaultLayout, 20), ErrLayoutIncompatible.Error())
+	// 新增机房在已有机房8号段的中间
+	assert.EqualError(t, wide.Compatible(DefaultLayout, 17), ErrLayoutIncompatible.Error())
+	// 机房0的号段扩大后覆盖已有机房1的号段
+	narrow := DefaultLayout
+	narrow.DataCenterBits = 3
+	assert.EqualError(t, narrow.Compatible(DefaultLayout, 0), ErrLayoutIncompatible.Error())
+
+	// 之前的ID不超过2^53，新增的机房在更高的位
+	js := IDLayout{SignBits: 11, DataCenterBits: 2, WorkerBits: 0, SequenceBits: 12}
+	assert.NoError(t, DefaultLayout.Compatible(js, 8))
+	assert.EqualError(t, DefaultLayout.Compatible(js, 0), ErrLayoutIncompatible.Error())
+	// 超出机房位
+	assert.EqualError(t, DefaultLayout.Compatible(DefaultLayout, 16), ErrLayoutDataCenter.Error())
+}
+
+func TestSegment_Layout(t *testing.T) {
+	l := IDLayout{SignBits: 11, DataCenterBits: 2, WorkerBits: 0, SequenceBits: 12}
+	seg := NewSegment(1, testDB, testTable, testSize, WithLayout(l))
+	seg.Expand(0, testSize)
+	id, err := seg.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, id, int64(1)<<51+1)
+}
@@ -0,0 +1,61 @@
+package generator
+
+// Option 发号器的可选配置
+type Option func(*options)
+
+type options struct {
+	layout      IDLayout
+	ringSize    int32
+	expandSize  int32
+	refillRatio float64
+}
+
+// WithLayout 指定ID的位分布，默认使用DefaultLayout。
+// 这里不再检查位分布，需要在启动时调用IDLayout.Compatible
+func WithLayout(layout IDLayout) Option {
+	return func(o *options) {
+		o.layout = layout
+	}
+}
+
+// WithRingSize Segment环的大小，即最多缓存的号段数量，默认64。
+// 小于expandSize的2倍时使用expandSize的2倍
+func WithRingSize(n int) Option {
+	return func(o *options) {
+		o.ringSize = int32(n)
+	}
+}
+
+// WithExpandSize Segment需要提前加载的号段数量，包括正在发号的号段，默认3。
+// 对延迟敏感的业务可以调大，对内存敏感的业务可以调小，最小为1
+func WithExpandSize(n int) Option {
+	return func(o *options) {
+		o.expandSize = int32(n)
+	}
+}
+
+// WithRefillRatio 当前号段剩余的比例不超过ratio时加载下一个号段，取值 (0, 1]，默认0.9，
+// 1 表示号段数量不够时立即加载
+func WithRefillRatio(ratio float64) Option {
+	return func(o *options) {
+		o.refillRatio = ratio
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{layout: DefaultLayout, ringSize: defaultRingSize, expandSize: defaultExpandSize, refillRatio: defaultRefillRatio}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.expandSize < 1 {
+		o.expandSize = defaultExpandSize
+	}
+	// 写游标和读游标最多相差 ringSize - 1
+	if o.ringSize < o.expandSize*2 {
+		o.ringSize = o.expandSize * 2
+	}
+	if o.refillRatio <= 0 || o.refillRatio > 1 {
+		o.refillRatio = defaultRefillRatio
+	}
+	return o
+}
@@ -7,50 +7,67 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 // A Segment 按照自然递增生成ID
 type Segment struct {
+	// max 加载过的最大值，使用atomic读写，放在第一个字段保证32位平台上的原子操作对齐
+	max int64
 	// dc 数据中心ID最高位，使用"|"和自增数字合并成ID
 	dc int64
+	// mask 机房位以下的计数位，由IDLayout决定
+	mask int64
 	// db, table 服务名称
 	db, table string
-	// ring 使用环缓存发号数据。减少缓存对象的生成，以及锁。
-	// 通过writeCursor写入位置和readCursor读取位置来处理缓存加入和退出逻辑
-	ring        []*Buffer
-	writeCursor int32
-	readCursor  int32
-	// last 最后发号数据，可不精确
-	last int64
+	// current 正在发号的号段，类型为*Buffer，没有号段时为emptyBuffer。
+	// 发号只读取current，不加锁；current 发完之后在mu保护下移动到下一个号段，只会向后移动，
+	// 所以同一个goroutine拿到的号码是递增的
+	current atomic.Value
+	// mu 保护ring、head、count、step 和 updateTime
+	mu sync.Mutex
+	// ring 使用环缓存发号数据，ring[head] 为正在发号的号段，之后count-1个为等待的号段。
+	// head 始终小于ringSize，不会溢出；count 在mu保护下使用atomic写入，advance 不加锁读取
+	ring  []*Buffer
+	head  int32
+	count int32
+	// ringSize 环的大小，expandSize 需要加载的缓存数量，
+	// refillRatio 当前缓存剩余的比例不超过该值并且缓存数量不够expandSize时加载
+	ringSize    int32
+	expandSize  int32
+	refillRatio float64
 	// step 批量导入的大小
 	step int64
 	// lastTimestamp 最后一次添加时间
 	updateTime time.Time
 }
 
+// emptyBuffer 没有号段时的current，发号返回ErrEmpty
+var emptyBuffer = &Buffer{disabled: 1}
+
 const (
 	// defaultRingSize 缓存的最大数量，需要至少 defaultExpandSize * 2，
 	defaultRingSize = 64
 	// defaultExpandSize 默认在ring中加载的缓存数量
 	defaultExpandSize = 3
+	// defaultRefillRatio 当前缓存发出10%之后加载下一个缓存
+	defaultRefillRatio = 0.9
 	// maxIntBits 支持最大位数 64
 	maxIntBits = 64
 )
 
 const (
-	// dataCenterBits 机房占位4位，最大支持32个
+	// dataCenterBits 默认机房占位4位，最大支持16个，可以通过IDLayout调整
 	dataCenterBits = uint(4)
-	// DataCenterMask 机房最大数量
+	// DataCenterMask 默认机房最大数量
 	DataCenterMask = int64(-1 ^ (-1 << dataCenterBits))
-	// sequenceBits 发号最多支持位数，sequenceMask 表示发号器最大递增数
-	sequenceBits    = maxIntBits - 1 - dataCenterBits
-	dataCenterShift = sequenceBits + dataCenterBits
-	sequenceMask    = int64(-1 ^ (-1 << dataCenterShift))
+	// sequenceBits 默认发号最多支持位数
+	sequenceBits = maxIntBits - 1 - dataCenterBits
 
-	segmentStringTemplate = "{dc:%d, db:%s, table:%s, last:%d, step:%d, " +
-		"updateTime:%s, ring:{%s}, readCursor:%d, writeCursor:%d}"
+	segmentStringTemplate = "{dc:%d, db:%s, table:%s, max:%d, step:%d, " +
+		"updateTime:%s, ring:{%s}, head:%d, count:%d}"
 )
 
 var (
@@ -64,132 +81,208 @@ var (
 	ErrExpandDuplicated = errors.New("expand buff duplicated")
 )
 
-// NewSegment 新的自增ID
-func NewSegment(dataCenter uint8, db, table string, step int64) *Segment {
-	dc := (int64(dataCenter) & DataCenterMask) << sequenceBits
-	ring := make([]*Buffer, defaultRingSize)
-	for i := range ring {
-		ring[i] = &Buffer{disabled: 1}
-	}
-	return &Segment{
-		dc:         dc,
-		db:         db,
-		table:      table,
-		ring:       ring,
-		step:       step,
-		updateTime: time.Now(),
+// NewSegment 新的自增ID，可以通过WithLayout指定ID的位分布，
+// WithRingSize、WithExpandSize 和 WithRefillRatio 调整缓存的数量和加载的时机
+func NewSegment(dataCenter uint8, db, table string, step int64, opts ...Option) *Segment {
+	o := newOptions(opts)
+	p := &Segment{
+		dc:          o.layout.DataCenterPrefix(dataCenter),
+		mask:        o.layout.CounterMask(),
+		db:          db,
+		table:       table,
+		ring:        make([]*Buffer, o.ringSize),
+		ringSize:    o.ringSize,
+		expandSize:  o.expandSize,
+		refillRatio: o.refillRatio,
+		step:        step,
+		updateTime:  time.Now(),
 	}
+	p.current.Store(emptyBuffer)
+	return p
 }
 
-// Expand 批量加入一组号码，插入新的buffer，插入后将写游标在环中向后移动一位
-// 这里没法写满整个ring， 写游标和读游标最多相差 ringSize - 1
-// 发号范围 (min, max]
+// Expand 批量加入一组号码，在环的末尾加入新的号段，发号范围 (min, min+step]。
+// 环中最多有 ringSize - 1 个号段，已经发完的号段先移出
 func (p *Segment) Expand(min, step int64) error {
-	// 判断游标位置超出范围, 写满的发生的概率远小于饥饿
-	if atomic.LoadInt32(&p.writeCursor)+1 >= atomic.LoadInt32(&p.readCursor)+defaultRingSize {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dropExhausted()
+	if p.count >= p.ringSize-1 {
 		return ErrFull
 	}
-	// 使用atomic 操作游标
-	nextCursor := atomic.AddInt32(&p.writeCursor, 1)
-
-	// atomic.AddInt32 得到的游标 -1肯定唯一
-	lastCursor := (nextCursor + defaultRingSize - 1) % defaultRingSize
-	if !p.ring[lastCursor].IsDisabled() {
-		return ErrExpandDuplicated
+	b := newBuffer(min, step)
+	p.ring[(p.head+p.count)%p.ringSize] = b
+	atomic.AddInt32(&p.count, 1)
+	if p.count == 1 {
+		p.current.Store(b)
 	}
 	p.step = step
 	p.updateTime = time.Now()
-	setBuffer(min, step, p.ring[lastCursor])
+	if max := min + step; max > atomic.LoadInt64(&p.max) {
+		atomic.StoreInt64(&p.max, max)
+	}
 	return nil
 }
 
-// ExpandSize 存储的缓存数量
-func (p Segment) ExpandSize() int32 {
-	// size 存储的缓存数量
-	size := p.writeCursor - p.readCursor
-	// 如果读写都在当前节点，且可用，size+1
-	if p.writeCursor == p.readCursor && !p.ring[p.readCursor%defaultRingSize].IsDisabled() {
-		size++
+// pop 移出正在发号的号段，下一个号段作为current，需要持有mu
+func (p *Segment) pop() {
+	p.ring[p.head] = nil
+	p.head = (p.head + 1) % p.ringSize
+	atomic.AddInt32(&p.count, -1)
+	if p.count > 0 {
+		p.current.Store(p.ring[p.head])
+		return
+	}
+	p.current.Store(emptyBuffer)
+}
+
+// dropExhausted 移出已经发完的号段，只有正在发号的号段会被消费，需要持有mu
+func (p *Segment) dropExhausted() {
+	for p.count > 0 && p.ring[p.head].Remainder() == 0 {
+		p.pop()
+	}
+}
+
+// advance b 发完之后移动到下一个号段，其他goroutine已经移动时直接返回。
+// 返回false表示没有可用的号段
+func (p *Segment) advance(b *Buffer) bool {
+	// 不加锁的快速判断，没有号段时发号不会争抢mu
+	if p.current.Load().(*Buffer) != b {
+		return true
+	}
+	if b == emptyBuffer && atomic.LoadInt32(&p.count) == 0 {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.current.Load().(*Buffer) != b {
+		return true
+	}
+	if p.count == 0 {
+		return false
+	}
+	p.pop()
+	return p.count > 0
+}
+
+// ExpandSize 存储的缓存数量，不包括已经发完的号段
+func (p *Segment) ExpandSize() int32 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.expandSize0()
+}
+
+func (p *Segment) expandSize0() int32 {
+	size := p.count
+	if size > 0 && p.ring[p.head].Remainder() == 0 {
+		size--
 	}
 	return size
 }
 
 // Last 获取最后一次发号数据
-func (p Segment) Last() (last int64) {
-	cur := atomic.LoadInt32(&p.readCursor)
-	b := p.ring[cur%defaultRingSize]
-	return b.Last()
-}
-
-// Len 获取缓存剩余的号码量，由于没有锁，此值不精确
-// 遍历可用的缓存，过滤不存在或者已经发完的缓存，然后累计剩余的号码
-func (p Segment) Len() (count int64) {
-	var b *Buffer
-	for i := p.readCursor; i < p.writeCursor; i++ {
-		b = p.ring[i%defaultRingSize]
-		if !b.IsDisabled() {
-			count += b.Remainder()
-		}
+func (p *Segment) Last() (last int64) {
+	return p.current.Load().(*Buffer).Last()
+}
+
+// Len 获取缓存剩余的号码量，并发发号时只是某一时刻的值
+func (p *Segment) Len() (count int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := int32(0); i < p.count; i++ {
+		count += p.ring[(p.head+i)%p.ringSize].Remainder()
 	}
 	return count
 }
 
-// Max ring中最大的值，用于切换backend
-func (p Segment) Max() (max int64) {
-	var b *Buffer
-	for i := 0; i < defaultRingSize; i++ {
-		b = p.ring[i]
-		if b.max > max {
-			max = b.max
-		}
-	}
-	return max
+// Max 从存储加载过的最大计数，不包含机房等位分布，用于切换backend
+func (p *Segment) Max() (max int64) {
+	return atomic.LoadInt64(&p.max)
 }
 
 // Table 获取类型名称
-func (p Segment) Table() string {
+func (p *Segment) Table() string {
 	return p.table
 }
 
 // DB 获取类型名称
-func (p Segment) DB() string {
+func (p *Segment) DB() string {
 	return p.db
 }
 
 // NeedExpand 检查剩余数量，判断是否需要加载更多。
-// 当buff大于2个，不在加载
-func (p Segment) NeedExpand() bool {
-	return p.ExpandSize() < defaultExpandSize
+// 没有可用的缓存时加载；缓存数量不够expandSize，并且当前缓存剩余的比例不超过refillRatio时加载
+func (p *Segment) NeedExpand() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	size := p.expandSize0()
+	if size <= 0 {
+		return true
+	}
+	if size >= p.expandSize {
+		return false
+	}
+	// 第一个没有发完的号段
+	b := p.ring[p.head]
+	if b.Remainder() == 0 {
+		b = p.ring[(p.head+1)%p.ringSize]
+	}
+	return b.remaining() <= p.refillRatio
 }
 
-// Next 获取读取游标 readCursor 位置缓存的Next值，没有错误则表示当前ID可用。
-// isDisabled 表示当前缓存已发完处于不可用状态，需要移动读游标 readCursor
+// Next 获取current的下一个号码，current 发完之后移动到下一个号段再获取，
+// 没有可用的号段时返回ErrEmpty
 func (p *Segment) Next() (id int64, err error) {
-	// 不存在初始化
-	cur := atomic.LoadInt32(&p.readCursor)
-	b := p.ring[cur%defaultRingSize]
-	id, isDisabled, err := b.Next()
-	if err != nil {
-		return 0, err
+	for {
+		b := p.current.Load().(*Buffer)
+		id, _, err = b.Next()
+		if err == nil {
+			// 合并机房标记位
+			return p.dc | id&p.mask, nil
+		}
+		if !p.advance(b) {
+			return 0, ErrEmpty
+		}
+	}
+}
+
+// NextN 批量获取号码，优先从current中一次取出连续的号码，
+// 不够时继续从下一个缓存中获取，直到取满n个或者没有可用的缓存。
+// 返回的号码数量可能小于n，一个都没有取到时返回ErrEmpty
+func (p *Segment) NextN(n int64) ([]int64, error) {
+	ids := make([]int64, 0, n)
+	for int64(len(ids)) < n {
+		b := p.current.Load().(*Buffer)
+		min, count, _, err := b.NextN(n - int64(len(ids)))
+		if err != nil {
+			if !p.advance(b) {
+				break
+			}
+			continue
+		}
+		for id := min + 1; id <= min+count; id++ {
+			// 合并机房标记位
+			ids = append(ids, p.dc|id&p.mask)
+		}
 	}
-	if isDisabled {
-		// Buffer.Next内部保证只有一个goroutine才能获取到b.max，
-		// 所以isDisabled=true只有一个，这里增加读游标不会出现并发问题
-		atomic.AddInt32(&p.readCursor, 1)
-		return 0, ErrEmpty
+	if len(ids) == 0 {
+		return nil, ErrEmpty
 	}
-	// 合并机房标记位
-	return p.dc | id&sequenceMask, nil
+	return ids, nil
 }
 
 // Step 获取当前大小
-func (p Segment) Step() int64 {
+func (p *Segment) Step() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.step
 }
 
 // String 打印出内部对象
-func (p Segment) String() string {
-	s := make([]string, defaultRingSize)
+func (p *Segment) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := make([]string, len(p.ring))
 	for i, v := range p.ring {
 		if v == nil {
 			s[i] = "nil"
@@ -197,12 +290,13 @@ func (p Segment) String() string {
 		}
 		s[i] = v.String()
 	}
-	return fmt.Sprintf(segmentStringTemplate, p.dc, p.db, p.table, p.last, p.step,
-		p.updateTime.String(), strings.Join(s, ","), p.readCursor,
-		p.writeCursor)
+	return fmt.Sprintf(segmentStringTemplate, p.dc, p.db, p.table, atomic.LoadInt64(&p.max), p.step,
+		p.updateTime.String(), strings.Join(s, ","), p.head, p.count)
 }
 
 // UpdateTime 获取更新数据时间
-func (p Segment) UpdateTime() time.Time {
+func (p *Segment) UpdateTime() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.updateTime
 }
@@ -2,6 +2,8 @@ package generator
 
 import (
 	"fmt"
+	"runtime"
+	"sync"
 	"testing"
 
 	"time"
@@ -90,6 +92,38 @@ func TestSegment_NeedExpand(t *testing.T) {
 	assert.False(t, seg.NeedExpand())
 }
 
+func TestSegment_NeedExpandRatio(t *testing.T) {
+	seg := NewSegment(testDC, testDB, testTable, testSize, WithExpandSize(2), WithRefillRatio(0.5))
+	seg.Expand(0, testSize)
+	// 当前缓存剩余超过一半时不加载
+	for i := 0; i < 4; i++ {
+		seg.Next()
+	}
+	assert.False(t, seg.NeedExpand())
+	seg.Next()
+	assert.True(t, seg.NeedExpand())
+	// 缓存数量达到expandSize时不再加载
+	seg.Expand(testSize, testSize)
+	assert.False(t, seg.NeedExpand())
+}
+
+func TestSegment_Options(t *testing.T) {
+	seg := NewSegment(testDC, testDB, testTable, testSize, WithRingSize(4), WithExpandSize(1))
+	assert.Equal(t, len(seg.ring), 4)
+	assert.True(t, seg.NeedExpand())
+	seg.Expand(0, testSize)
+	assert.False(t, seg.NeedExpand())
+	for i := 1; i < 3; i++ {
+		assert.NoError(t, seg.Expand(int64(i)*testSize, testSize))
+	}
+	assert.EqualError(t, seg.Expand(3*testSize, testSize), ErrFull.Error())
+
+	// ring 至少为expandSize的2倍，ratio 超出范围时使用默认值
+	seg = NewSegment(testDC, testDB, testTable, testSize, WithRingSize(2), WithExpandSize(3), WithRefillRatio(2))
+	assert.Equal(t, len(seg.ring), 6)
+	assert.Equal(t, seg.refillRatio, defaultRefillRatio)
+}
+
 func TestSegment_Next(t *testing.T) {
 	seg := NewSegment(testDC, testDB, testTable, testSize)
 	_, err := seg.Next()
@@ -131,6 +165,121 @@ func TestSegment_Next2(t *testing.T) {
 	assert.Error(t, ErrEmpty)
 }
 
+func TestSegment_NextN(t *testing.T) {
+	seg := NewSegment(testDC, testDB, testTable, testSize)
+	_, err := seg.NextN(3)
+	assert.EqualError(t, err, ErrEmpty.Error())
+
+	seg.Expand(0, 2)
+	seg.Expand(2, 2)
+
+	// 跨越多个缓存获取
+	ids, err := seg.NextN(3)
+	assert.NoError(t, err)
+	assert.Equal(t, ids, []int64{1, 2, 3})
+
+	// 剩余不足时只返回部分
+	ids, err = seg.NextN(3)
+	assert.NoError(t, err)
+	assert.Equal(t, ids, []int64{4})
+
+	_, err = seg.NextN(3)
+	assert.EqualError(t, err, ErrEmpty.Error())
+}
+
+// TestSegment_Concurrent 并发发号和加载，环很小，head 会在环中循环上千次。
+// 所有号码只发一次，同一个goroutine拿到的号码递增，使用 go test -race 检查数据竞争
+func TestSegment_Concurrent(t *testing.T) {
+	const (
+		workers = 8
+		step    = 7
+		expands = 20000
+	)
+	seg := NewSegment(testDC, testDB, testTable, step, WithRingSize(4), WithExpandSize(2))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := int64(0); i < expands; {
+			if err := seg.Expand(i*step, step); err != nil {
+				runtime.Gosched()
+				continue
+			}
+			i++
+		}
+	}()
+	// 并发读取状态
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			// 让出CPU，单核时一直读取会让mu进入饥饿模式，加载和发号变慢
+			runtime.Gosched()
+			seg.Len()
+			seg.Max()
+			seg.Last()
+			seg.ExpandSize()
+			seg.NeedExpand()
+			seg.Step()
+			seg.UpdateTime()
+			_ = seg.String()
+		}
+	}()
+
+	results := make([][]int64, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for {
+				// 加载结束之后仍然取不到号码，说明已经发完
+				var finished bool
+				select {
+				case <-done:
+					finished = true
+				default:
+				}
+				var got []int64
+				if w%2 == 0 {
+					if id, err := seg.Next(); err == nil {
+						got = []int64{id}
+					}
+				} else {
+					got, _ = seg.NextN(3)
+				}
+				if len(got) == 0 {
+					if finished {
+						return
+					}
+					runtime.Gosched()
+					continue
+				}
+				results[w] = append(results[w], got...)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	seen := make(map[int64]bool, expands*step)
+	for _, ids := range results {
+		for i, id := range ids {
+			if seen[id] {
+				t.Fatalf("duplicated id %d", id)
+			}
+			seen[id] = true
+			if i > 0 && id <= ids[i-1] {
+				t.Fatalf("id %d after %d in the same goroutine", id, ids[i-1])
+			}
+		}
+	}
+	assert.Equal(t, len(seen), expands*step)
+	assert.Equal(t, seg.Max(), int64(expands*step))
+	assert.True(t, seg.head < seg.ringSize)
+}
+
 func TestSegment_Step(t *testing.T) {
 	seg := NewSegment(testDC, testDB, testTable, testSize)
 
@@ -171,7 +320,9 @@ func BenchmarkSegment_Expand(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		//use b.N for looping
 		seg.Expand(int64(i)*step, step)
-		seg.readCursor++
+		seg.mu.Lock()
+		seg.pop()
+		seg.mu.Unlock()
 	}
 }
 
@@ -0,0 +1,188 @@
+// Snowflake按照时间戳生成ID，不依赖存储。适用于不要求连续递增的业务。
+// ID结构和Segment保持一致，最高位为符号位，紧接着是机房位，方便分库时统一去掉机房位。
+// 默认位分布依次为：符号位1位，机房位4位，毫秒时间戳41位，worker 6位，毫秒内序号12位。
+// snowflake 的介绍页：https://github.com/twitter-archive/snowflake
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// workerBits 默认worker占位6位，同一个机房最多支持64个进程
+	workerBits = uint(6)
+	// WorkerMask 默认worker最大数量
+	WorkerMask = int64(-1 ^ (-1 << workerBits))
+	// snowflakeSequenceBits 默认每毫秒内的序号位数，每毫秒最多生成4096个ID
+	snowflakeSequenceBits = uint(12)
+
+	// DefaultEpoch 时间戳起点 2019-01-01 00:00:00 UTC，单位毫秒
+	DefaultEpoch = int64(1546300800000)
+	// maxBackwardWait 时钟回拨的最大等待时间，超过则直接报错
+	maxBackwardWait = 5 * time.Millisecond
+
+	snowflakeStringTemplate = "{dc:%d, worker:%d, db:%s, table:%s, last:%d, " +
+		"lastTimestamp:%d, sequence:%d, updateTime:%s}"
+)
+
+// ErrClockBackwards 系统时钟回拨超过maxBackwardWait，拒绝发号防止重复
+var ErrClockBackwards = errors.New("clock moved backwards")
+
+// A Snowflake 按照时间戳生成ID
+type Snowflake struct {
+	mu sync.Mutex
+	// dc 数据中心ID最高位，worker 进程ID，使用"|"和时间戳、序号合并成ID
+	dc, worker int64
+	// timestampShift, timestampMask, sequenceMask 由IDLayout决定
+	timestampShift uint
+	timestampMask  int64
+	sequenceMask   int64
+	// db, table 服务名称
+	db, table string
+	// lastTimestamp 最后一次发号的时间戳，相对epoch的毫秒数
+	lastTimestamp int64
+	// sequence 当前毫秒内的序号
+	sequence int64
+	// last 最后发号数据
+	last int64
+	// updateTime 最后一次发号时间
+	updateTime time.Time
+	// now 获取当前时间，测试中可以替换
+	now func() time.Time
+}
+
+// NewSnowflake 新的时间戳ID，worker超出位分布的范围会被截断，
+// 可以通过WithLayout指定ID的位分布
+func NewSnowflake(dataCenter uint8, worker uint16, db, table string, opts ...Option) *Snowflake {
+	layout := newOptions(opts).layout
+	return &Snowflake{
+		dc:             layout.DataCenterPrefix(dataCenter),
+		worker:         (int64(worker) & layout.WorkerMask()) << layout.SequenceBits,
+		timestampShift: layout.WorkerBits + layout.SequenceBits,
+		timestampMask:  int64(-1 ^ (-1 << layout.TimestampBits())),
+		sequenceMask:   layout.SequenceMask(),
+		db:             db,
+		table:          table,
+		updateTime:     time.Now(),
+		now:            time.Now,
+	}
+}
+
+// timestamp 当前相对epoch的毫秒数
+func (p *Snowflake) timestamp() int64 {
+	return p.now().UnixNano()/int64(time.Millisecond) - DefaultEpoch
+}
+
+// Next 生成下一个ID。同一毫秒内序号用完会等待到下一毫秒；
+// 时钟回拨在maxBackwardWait以内会等待时钟追上，否则返回ErrClockBackwards
+func (p *Snowflake) Next() (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ts := p.timestamp()
+	if ts < p.lastTimestamp {
+		wait := time.Duration(p.lastTimestamp-ts) * time.Millisecond
+		if wait > maxBackwardWait {
+			return 0, ErrClockBackwards
+		}
+		time.Sleep(wait)
+		if ts = p.timestamp(); ts < p.lastTimestamp {
+			return 0, ErrClockBackwards
+		}
+	}
+	if ts == p.lastTimestamp {
+		p.sequence = (p.sequence + 1) & p.sequenceMask
+		// 当前毫秒序号用完，等待下一毫秒
+		for p.sequence == 0 && ts <= p.lastTimestamp {
+			time.Sleep(time.Millisecond / 10)
+			ts = p.timestamp()
+		}
+	} else {
+		p.sequence = 0
+	}
+	p.lastTimestamp = ts
+	p.updateTime = p.now()
+	p.last = p.dc | (ts&p.timestampMask)<<p.timestampShift | p.worker | p.sequence
+	return p.last, nil
+}
+
+// NextN 批量生成号码，单个号码生成失败时返回已经生成的号码
+func (p *Snowflake) NextN(n int64) ([]int64, error) {
+	ids := make([]int64, 0, n)
+	for int64(len(ids)) < n {
+		id, err := p.Next()
+		if err != nil {
+			if len(ids) > 0 {
+				break
+			}
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Expand 时间戳发号不需要从存储加载，直接忽略
+func (p *Snowflake) Expand(_, _ int64) error {
+	return nil
+}
+
+// NeedExpand 时间戳发号不需要从存储加载
+func (p *Snowflake) NeedExpand() bool {
+	return false
+}
+
+// Last 获取最后一次发号数据
+func (p *Snowflake) Last() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.last
+}
+
+// Len 当前毫秒内剩余可发的号码数量
+func (p *Snowflake) Len() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timestamp() != p.lastTimestamp {
+		return p.sequenceMask + 1
+	}
+	return p.sequenceMask - p.sequence
+}
+
+// Max 当前毫秒内可以生成的最大ID，包含机房、时间戳和worker，和存储的计数无关
+func (p *Snowflake) Max() int64 {
+	return p.dc | (p.timestamp()&p.timestampMask)<<p.timestampShift | p.worker | p.sequenceMask
+}
+
+// Table 获取类型名称
+func (p *Snowflake) Table() string {
+	return p.table
+}
+
+// DB 获取类型名称
+func (p *Snowflake) DB() string {
+	return p.db
+}
+
+// Step 每毫秒最多生成的号码数量
+func (p *Snowflake) Step() int64 {
+	return p.sequenceMask + 1
+}
+
+// UpdateTime 获取最后一次发号时间
+func (p *Snowflake) UpdateTime() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.updateTime
+}
+
+// String 打印出内部对象
+func (p *Snowflake) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return fmt.Sprintf(snowflakeStringTemplate, p.dc, p.worker, p.db, p.table, p.last,
+		p.lastTimestamp, p.sequence, p.updateTime.String())
+}
@@ -0,0 +1,80 @@
+package generator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testWorker uint16 = 3
+
+func TestSnowflake_Next(t *testing.T) {
+	sf := NewSnowflake(testDC, testWorker, testDB, testTable)
+	var last int64
+	for i := 0; i < 10000; i++ {
+		id, err := sf.Next()
+		assert.NoError(t, err)
+		if id <= last {
+			t.Fatalf("id not increase, last:%d id:%d", last, id)
+		}
+		last = id
+	}
+	assert.Equal(t, last, sf.Last())
+}
+
+func TestSnowflake_Next2(t *testing.T) {
+	var testDC2 uint8 = 1
+	sf := NewSnowflake(testDC2, testWorker, testDB, testTable)
+	id, err := sf.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, id>>sequenceBits, int64(testDC2))
+	assert.Equal(t, id>>snowflakeSequenceBits&WorkerMask, int64(testWorker))
+	assert.Equal(t, id&DefaultLayout.SequenceMask(), int64(0))
+}
+
+func TestSnowflake_Sequence(t *testing.T) {
+	now := time.Now()
+	sf := NewSnowflake(testDC, testWorker, testDB, testTable)
+	sf.now = func() time.Time { return now }
+
+	first, err := sf.Next()
+	assert.NoError(t, err)
+	second, err := sf.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, second, first+1)
+	assert.Equal(t, sf.Len(), DefaultLayout.SequenceMask()-1)
+}
+
+func TestSnowflake_ClockBackwards(t *testing.T) {
+	now := time.Now()
+	sf := NewSnowflake(testDC, testWorker, testDB, testTable)
+	sf.now = func() time.Time { return now }
+	_, err := sf.Next()
+	assert.NoError(t, err)
+
+	// 超过最大等待时间直接报错
+	sf.now = func() time.Time { return now.Add(-time.Second) }
+	_, err = sf.Next()
+	assert.EqualError(t, err, ErrClockBackwards.Error())
+
+	// 时钟恢复后继续发号
+	sf.now = func() time.Time { return now.Add(time.Millisecond) }
+	_, err = sf.Next()
+	assert.NoError(t, err)
+}
+
+func TestSnowflake_NeedExpand(t *testing.T) {
+	sf := NewSnowflake(testDC, testWorker, testDB, testTable)
+	assert.False(t, sf.NeedExpand())
+	assert.NoError(t, sf.Expand(0, testSize))
+	assert.False(t, sf.NeedExpand())
+}
+
+func BenchmarkSnowflake_Next(b *testing.B) {
+	sf := NewSnowflake(testDC, testWorker, testDB, testTable)
+	for i := 0; i < b.N; i++ {
+		//use b.N for looping
+		sf.Next()
+	}
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+var (
+	// ErrTableNotExists 计数不存在，table还没有发过号或者已经被删除
+	ErrTableNotExists = errors.New("table does not exist")
+	// ErrAdminNotSupported 存储不支持管理接口
+	ErrAdminNotSupported = errors.New("store does not support admin")
+)
+
+// An Admin 管理存储中的db和table，接入新业务时提前创建db，不需要直接操作存储
+type Admin interface {
+	// CreateDB 创建db，已经存在不报错
+	CreateDB(ctx context.Context, dataCenter uint8, db string) error
+	// ListDBs 机房下所有的db，按名称排序
+	ListDBs(ctx context.Context, dataCenter uint8) ([]string, error)
+	// ListTables db下所有的table，按名称排序
+	ListTables(ctx context.Context, dataCenter uint8, db string) ([]string, error)
+	// GetCounter 已经分配的最大值，下一次Range返回该值；不存在返回ErrTableNotExists
+	GetCounter(ctx context.Context, dataCenter uint8, db, table string) (int64, error)
+	// DeleteTable 删除计数，之后Range从0开始，只能删除不再使用的table
+	DeleteTable(ctx context.Context, dataCenter uint8, db, table string) error
+}
+
+// A Wrapper 包装其他存储的存储，例如Watermark，Unwrap 返回被包装的存储
+type Wrapper interface {
+	Unwrap() Store
+}
+
+// AdminOf 获取存储的管理接口，包装的存储使用被包装的存储的管理接口
+func AdminOf(s Store) (Admin, error) {
+	for s != nil {
+		if a, ok := s.(Admin); ok {
+			return a, nil
+		}
+		w, ok := s.(Wrapper)
+		if !ok {
+			break
+		}
+		s = w.Unwrap()
+	}
+	return nil, ErrAdminNotSupported
+}
+
+// sortedKeys 去重后排序
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
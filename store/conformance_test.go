@@ -0,0 +1,148 @@
+package store_test
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/sirupsen/logrus"
+
+	"github.com/luw2007/rabbitid/store"
+	"github.com/luw2007/rabbitid/store/storetest"
+)
+
+const (
+	testRedisURI = "127.0.0.1:6379"
+	testEtcdURI  = "127.0.0.1:2379"
+	testZKURI    = "127.0.0.1:2181"
+)
+
+func TestMemory_Conformance(t *testing.T) {
+	storetest.RunSuite(t, func(t *testing.T) store.Store {
+		return store.NewMemory()
+	})
+}
+
+func TestMiddleware_Conformance(t *testing.T) {
+	storetest.RunSuite(t, func(t *testing.T) store.Store {
+		return store.Chain(store.NewMemory(),
+			store.CircuitBreaker(3, time.Second),
+			store.Retry(3, store.ExponentialBackoff(time.Millisecond, 10*time.Millisecond)),
+			store.Timeout(time.Second))
+	})
+}
+
+func TestWatermark_Conformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rabbitid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var n int
+	storetest.RunSuite(t, func(t *testing.T) store.Store {
+		n++
+		path := filepath.Join(dir, fmt.Sprintf("watermark_%d.json", n))
+		s, err := store.NewWatermark(store.NewMemory(), path, logrus.NewEntry(logrus.New()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestReplicated_Conformance(t *testing.T) {
+	storetest.RunSuite(t, func(t *testing.T) store.Store {
+		s, err := store.NewReplicated([]store.Store{store.NewMemory(), store.NewMemory(), store.NewMemory()},
+			logrus.NewEntry(logrus.New()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestRedis_Conformance(t *testing.T) {
+	storetest.RunSuite(t, func(t *testing.T) store.Store {
+		s, err := store.NewRedis(testRedisURI, logrus.NewEntry(logrus.New()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestEtcd_Conformance(t *testing.T) {
+	storetest.RunSuite(t, func(t *testing.T) store.Store {
+		return store.NewEtcd(testEtcdURI, logrus.NewEntry(logrus.New()))
+	})
+}
+
+func TestSQLite_Conformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rabbitid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dsn := filepath.Join(dir, "rabbitid.db") + "?_busy_timeout=5000"
+
+	storetest.RunSuite(t, func(t *testing.T) store.Store {
+		s := store.NewSQL("sqlite", dsn, logrus.NewEntry(logrus.New()))
+		if err := s.Init(storetest.DataCenter); err != nil {
+			t.Fatal(err)
+		}
+		// 需要提前创建db
+		db, err := sql.Open("sqlite3", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		_, err = db.Exec("INSERT OR IGNORE INTO rabbitid_db (dc, db) VALUES (?, ?)", storetest.DataCenter, storetest.DB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestFile_Conformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rabbitid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var last store.File
+	storetest.RunSuite(t, func(t *testing.T) store.Store {
+		// 同一个文件只能打开一次
+		if last != (store.File{}) {
+			last.Close()
+		}
+		last = store.NewFile(filepath.Join(dir, "rabbitid.bolt"), logrus.NewEntry(logrus.New()))
+		return last
+	})
+	last.Close()
+}
+
+func TestZK_Conformance(t *testing.T) {
+	storetest.RunSuite(t, func(t *testing.T) store.Store {
+		// zk 需要提前创建db目录
+		conn, _, err := zk.Connect([]string{testZKURI}, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		for _, node := range []string{"/rabbitid", fmt.Sprintf("/rabbitid/%d", storetest.DataCenter),
+			fmt.Sprintf("/rabbitid/%d/%s", storetest.DataCenter, storetest.DB)} {
+			if _, err := conn.Create(node, nil, 0, store.DefaultACL); err != nil && err != zk.ErrNodeExists {
+				t.Fatal(err)
+			}
+		}
+		return store.NewZK(testZKURI, logrus.NewEntry(logrus.New()))
+	})
+}
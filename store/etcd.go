@@ -15,15 +15,20 @@ import (
 )
 
 const (
-	etcdTPL    = "%s/%d/%s/%s"
-	etcdRoot   = "/rabbitid"
-	retryTimes = 10
+	etcdTPL = "%s/%d/%s/%s"
+	// etcdRoot 默认的key前缀，可以通过EtcdRoot修改
+	etcdRoot = "/rabbitid"
 )
 
 // A Etcd 使用etcd 存储发号元数据
 type Etcd struct {
-	KV  v3.KV
-	log *logrus.Entry
+	KV     v3.KV
+	client *v3.Client
+	// root key前缀，多个产品共用etcd时区分
+	root string
+	// requestTimeout 单次请求的超时时间，0表示只使用调用方的ctx
+	requestTimeout time.Duration
+	log            *logrus.Entry
 }
 
 var (
@@ -33,49 +38,97 @@ var (
 	ErrEtcdNotFound = errors.New("etcd key not found")
 )
 
-// NewEtcd 获取redis实例
-func NewEtcd(clientURI string, logger *logrus.Entry) Etcd {
-	cli, err := v3.New(v3.Config{
+// NewEtcd 获取etcd实例，clientURI 为逗号分隔的地址，
+// 可以通过EtcdTLS、EtcdCredentials、EtcdRoot等指定证书、认证和key前缀
+func NewEtcd(clientURI string, logger *logrus.Entry, options ...EtcdOption) Etcd {
+	p, err := dialEtcd(clientURI, logger, options...)
+	if err != nil {
+		log.Fatalln("etcd init error", clientURI, err.Error())
+	}
+	return p
+}
+
+// dialEtcd 和NewEtcd相同，出错时返回错误
+func dialEtcd(clientURI string, logger *logrus.Entry, options ...EtcdOption) (Etcd, error) {
+	config := etcdConfig{root: etcdRoot, dialTimeout: DefaultEtcdDialTimeout}
+	for _, option := range options {
+		if err := option(&config); err != nil {
+			return Etcd{}, err
+		}
+	}
+	cfg := v3.Config{
 		Endpoints:   strings.Split(clientURI, ","),
-		DialTimeout: 5 * time.Second,
-	})
+		DialTimeout: config.dialTimeout,
+		Username:    config.user,
+		Password:    config.pass,
+	}
+	if !config.tls.Empty() || config.tls.TrustedCAFile != "" {
+		tlsConfig, err := config.tls.ClientConfig()
+		if err != nil {
+			return Etcd{}, err
+		}
+		cfg.TLS = tlsConfig
+	}
+	cli, err := v3.New(cfg)
 	if err != nil {
-		log.Fatalln("client connect error", clientURI, err.Error())
+		return Etcd{}, err
 	}
-	return Etcd{KV: v3.NewKV(cli), log: logger}
+	return Etcd{KV: v3.NewKV(cli), client: cli, root: config.root, requestTimeout: config.requestTimeout, log: logger}, nil
 }
 
-// Range 分片分配进度, 返回v 表示可用范围[v, v+size)
+// withTimeout 单次请求使用的ctx
+func (p Etcd) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.requestTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, p.requestTimeout)
+}
+
+// Range 分片分配进度, 返回v 表示可用范围(v, v+size]
 func (p Etcd) Range(ctx context.Context, dataCenter uint8, db, table string, size int64) (int64, error) {
-	biz := fmt.Sprintf(etcdTPL, etcdRoot, dataCenter, db, table)
+	biz := fmt.Sprintf(etcdTPL, p.root, dataCenter, db, table)
 	l := p.log.WithFields(logrus.Fields{"action": "range", "biz": biz, "size": size})
 	last, err := p.last(ctx, l, biz)
 	// 查找旧值，可能不存在
 	if err != nil && err != ErrEtcdNotFound {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
 		l.WithError(err).Error("not found")
 		return 0, ErrEtcdFail
 	}
-	// 存在多进程竞争的问题，这里乐观认为会成功
-	for i := 0; i < retryTimes; i++ {
-		last, err = p.update(ctx, l, biz, last, size)
+	// 存在多进程竞争的问题，这里乐观认为会成功。
+	// 版本冲突表示其他进程已经分配成功，一直重试直到ctx超时，其他错误交给Retry中间件
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		var ok bool
+		last, ok, err = p.update(ctx, l, biz, last, size)
 		// Txn 查找必定存在，不存在需要抛错
-		switch err {
-		case nil:
+		switch {
+		case err == nil && ok:
 			return last, nil
-		case ErrEtcdNotFound:
+		case err == nil:
+			continue
+		case err == ErrEtcdNotFound:
 			l.WithField("last", last).WithError(err).Error("etcd update fail")
 			return 0, err
 		default:
-			l.WithField("last", last).WithError(err).Error("etcd update fail, try again")
-			continue
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			l.WithField("last", last).WithError(err).Error("etcd update fail")
+			return 0, ErrEtcdFail
 		}
 	}
-	return 0, ErrEtcdFail
 }
 
 // last 获取上一次分配的数据
 func (p Etcd) last(ctx context.Context, l *logrus.Entry, biz string) (int64, error) {
 	// 获取旧的数据
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 	resp, err := p.KV.Get(ctx, biz)
 	if err != nil {
 		l.WithError(err).Error("notfound last")
@@ -84,14 +137,16 @@ func (p Etcd) last(ctx context.Context, l *logrus.Entry, biz string) (int64, err
 	return getKvsByKey(resp.Kvs, biz)
 }
 
-// update 更新 etcd 存储数据
-func (p Etcd) update(ctx context.Context, l *logrus.Entry, biz string, min int64, size int64) (int64, error) {
+// update 更新 etcd 存储数据，ok 表示更新成功返回min，否则返回当前存储的值用于重试
+func (p Etcd) update(ctx context.Context, l *logrus.Entry, biz string, min int64, size int64) (int64, bool, error) {
 	var err error
 	var resp *v3.TxnResponse
 
 	now := strconv.FormatInt(min, 10)
 	next := strconv.FormatInt(min+size, 10)
 	l.WithFields(logrus.Fields{"action": "Txn", "now": now, "next": next})
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 	// 新增还是更新
 	if min == 0 {
 		resp, err = p.KV.Txn(ctx).
@@ -108,12 +163,13 @@ func (p Etcd) update(ctx context.Context, l *logrus.Entry, biz string, min int64
 	}
 	if err != nil {
 		l.WithError(err).Error("Txn error")
-		return 0, err
+		return 0, false, err
 	}
 	if resp.Succeeded {
-		return min, nil
+		return min, true, nil
 	}
-	return getKvsByKey(resp.Responses[0].GetResponseRange().GetKvs(), biz)
+	last, err := getKvsByKey(resp.Responses[0].GetResponseRange().GetKvs(), biz)
+	return last, false, err
 }
 
 // Ping 测试连接状态
@@ -121,7 +177,9 @@ func (p Etcd) Ping(ctx context.Context) error {
 	if p.KV == nil {
 		return ErrEtcdFail
 	}
-	_, err := p.KV.Get(ctx, etcdRoot)
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	_, err := p.KV.Get(ctx, p.root)
 	return err
 }
 
@@ -138,4 +196,70 @@ func getKvsByKey(kvs []*mvccpb.KeyValue, s string) (int64, error) {
 // Init 这里可以完成初始化方法，保证服务的可用
 func (p Etcd) Init(dataCenter uint8) error { return nil }
 
+// Close 关闭连接
+func (p Etcd) Close() error {
+	if p.client == nil {
+		return nil
+	}
+	return p.client.Close()
+}
+
 func (p Etcd) BlockDB(dataCenter uint8, db string) bool { return false }
+
+// CreateDB 写入{root}/{dc}/{db}作为db的标记，etcd的Range不检查db
+func (p Etcd) CreateDB(ctx context.Context, dataCenter uint8, db string) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	_, err := p.KV.Put(ctx, fmt.Sprintf("%s/%d/%s", p.root, dataCenter, db), "")
+	return err
+}
+
+// ListDBs 机房下创建过的db和有计数的db
+func (p Etcd) ListDBs(ctx context.Context, dataCenter uint8) ([]string, error) {
+	prefix := fmt.Sprintf("%s/%d/", p.root, dataCenter)
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	resp, err := p.KV.Get(ctx, prefix, v3.WithPrefix(), v3.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+	dbs := make(map[string]bool)
+	for _, kv := range resp.Kvs {
+		dbs[strings.SplitN(string(kv.Key)[len(prefix):], "/", 2)[0]] = true
+	}
+	return sortedKeys(dbs), nil
+}
+
+// ListTables db下有计数的table
+func (p Etcd) ListTables(ctx context.Context, dataCenter uint8, db string) ([]string, error) {
+	prefix := fmt.Sprintf("%s/%d/%s/", p.root, dataCenter, db)
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	resp, err := p.KV.Get(ctx, prefix, v3.WithPrefix(), v3.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+	tables := make(map[string]bool)
+	for _, kv := range resp.Kvs {
+		tables[string(kv.Key)[len(prefix):]] = true
+	}
+	return sortedKeys(tables), nil
+}
+
+// GetCounter 已经分配的最大值
+func (p Etcd) GetCounter(ctx context.Context, dataCenter uint8, db, table string) (int64, error) {
+	biz := fmt.Sprintf(etcdTPL, p.root, dataCenter, db, table)
+	max, err := p.last(ctx, p.log.WithFields(logrus.Fields{"action": "counter", "biz": biz}), biz)
+	if err == ErrEtcdNotFound {
+		return 0, ErrTableNotExists
+	}
+	return max, err
+}
+
+// DeleteTable 删除计数
+func (p Etcd) DeleteTable(ctx context.Context, dataCenter uint8, db, table string) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	_, err := p.KV.Delete(ctx, fmt.Sprintf(etcdTPL, p.root, dataCenter, db, table))
+	return err
+}
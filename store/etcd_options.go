@@ -0,0 +1,85 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/pkg/transport"
+)
+
+const (
+	// DefaultEtcdDialTimeout is the default timeout to establish a connection to etcd.
+	DefaultEtcdDialTimeout = 5 * time.Second
+)
+
+type etcdConfig struct {
+	root           string
+	user, pass     string
+	tls            transport.TLSInfo
+	dialTimeout    time.Duration
+	requestTimeout time.Duration
+}
+
+// EtcdOption functions configure the etcd store, like Option for ZooKeeper.
+type EtcdOption func(*etcdConfig) error
+
+// EtcdTLS returns an EtcdOption specifying the client certificate, key and
+// trusted CA files. certFile and keyFile can be empty when the server does
+// not require client certificates.
+func EtcdTLS(certFile, keyFile, caFile string) EtcdOption {
+	return func(c *etcdConfig) error {
+		if (certFile == "") != (keyFile == "") {
+			return errors.New("invalid etcd tls (cert and key must be set together)")
+		}
+		c.tls = transport.TLSInfo{CertFile: certFile, KeyFile: keyFile, TrustedCAFile: caFile}
+		return nil
+	}
+}
+
+// EtcdCredentials returns an EtcdOption specifying a user/password combination
+// used for etcd authentication.
+func EtcdCredentials(user, pass string) EtcdOption {
+	return func(c *etcdConfig) error {
+		if user == "" || pass == "" {
+			return ErrInvalidCredentials
+		}
+		c.user, c.pass = user, pass
+		return nil
+	}
+}
+
+// EtcdDialTimeout returns an EtcdOption specifying a non-default dial timeout.
+func EtcdDialTimeout(t time.Duration) EtcdOption {
+	return func(c *etcdConfig) error {
+		if t <= 0 {
+			return errors.New("invalid etcd dial timeout")
+		}
+		c.dialTimeout = t
+		return nil
+	}
+}
+
+// EtcdRequestTimeout returns an EtcdOption specifying the timeout of every
+// single etcd request, on top of the caller's context.
+func EtcdRequestTimeout(t time.Duration) EtcdOption {
+	return func(c *etcdConfig) error {
+		if t <= 0 {
+			return errors.New("invalid etcd request timeout")
+		}
+		c.requestTimeout = t
+		return nil
+	}
+}
+
+// EtcdRoot returns an EtcdOption specifying the key prefix of all counters,
+// so that several products can share one etcd cluster. Default is /rabbitid.
+func EtcdRoot(root string) EtcdOption {
+	return func(c *etcdConfig) error {
+		if !strings.HasPrefix(root, "/") || strings.HasSuffix(root, "/") {
+			return errors.New("invalid etcd root (must start and not end with /)")
+		}
+		c.root = root
+		return nil
+	}
+}
@@ -0,0 +1,29 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcd_Options(t *testing.T) {
+	var c etcdConfig
+	assert.Error(t, EtcdTLS("cert.pem", "", "")(&c))
+	assert.NoError(t, EtcdTLS("cert.pem", "key.pem", "ca.pem")(&c))
+	assert.Equal(t, c.tls.TrustedCAFile, "ca.pem")
+
+	assert.EqualError(t, EtcdCredentials("user", "")(&c), ErrInvalidCredentials.Error())
+	assert.NoError(t, EtcdCredentials("user", "pass")(&c))
+	assert.Equal(t, c.user, "user")
+
+	assert.Error(t, EtcdDialTimeout(0)(&c))
+	assert.NoError(t, EtcdRequestTimeout(time.Second)(&c))
+	assert.Equal(t, c.requestTimeout, time.Second)
+
+	for _, root := range []string{"", "rabbitid", "/rabbitid/"} {
+		assert.Error(t, EtcdRoot(root)(&c), root)
+	}
+	assert.NoError(t, EtcdRoot("/product/rabbitid")(&c))
+	assert.Equal(t, c.root, "/product/rabbitid")
+}
@@ -20,8 +20,11 @@ func ExampleNewEtcd() {
 
 func ExampleNewRedis() {
 	log := logrus.NewEntry(logrus.New())
-	db := NewRedis(testRedis, log)
-	err := db.Ping(context.TODO())
+	db, err := NewRedis(testRedis, log)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.Ping(context.TODO())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	fileTPL  = "%s/%d/%s/%s"
+	fileRoot = "/rabbitid"
+	// fileBucket 所有计数保存在同一个bucket中，key 和etcd、zk保持一致
+	fileBucket = "rabbitid"
+	// fileLockTimeout 获取文件锁的超时时间，文件已经被其他进程打开时报错
+	fileLockTimeout = time.Second
+)
+
+// ErrFileLocked 数据文件已经被其他进程打开
+var ErrFileLocked = errors.New("file store is locked by another process")
+
+// A File 使用本地文件(bbolt)存储发号进度，适合开发环境和单节点部署。
+// 每次Range都在一个fsync的事务中完成，bbolt打开文件时会加文件锁，同一个文件只能被一个进程使用
+type File struct {
+	db  *bolt.DB
+	log *logrus.Entry
+}
+
+// NewFile 打开数据文件，不存在则创建
+func NewFile(path string, logger *logrus.Entry) File {
+	p, err := newFile(path, logger)
+	if err != nil {
+		log.Fatalln("file store open error", path, err.Error())
+	}
+	return p
+}
+
+// newFile 和NewFile相同，出错时返回错误
+func newFile(path string, logger *logrus.Entry) (File, error) {
+	db, err := openFile(path)
+	if err != nil {
+		return File{}, err
+	}
+	return File{db: db, log: logger.WithField("store", "file")}, nil
+}
+
+// openFile 打开数据文件并加锁，超时返回ErrFileLocked
+func openFile(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: fileLockTimeout})
+	if err == bolt.ErrTimeout {
+		return nil, ErrFileLocked
+	}
+	return db, err
+}
+
+// Range 分片分配进度, 返回v 表示可用范围(v, v+size]
+func (p File) Range(ctx context.Context, dataCenter uint8, db, table string, size int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	biz := fmt.Sprintf(fileTPL, fileRoot, dataCenter, db, table)
+	var min int64
+	err := p.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(fileBucket))
+		if err != nil {
+			return err
+		}
+		if v := b.Get([]byte(biz)); v != nil {
+			if min, err = strconv.ParseInt(string(v), 10, 64); err != nil {
+				return err
+			}
+		}
+		return b.Put([]byte(biz), []byte(strconv.FormatInt(min+size, 10)))
+	})
+	if err != nil {
+		p.log.WithFields(logrus.Fields{"action": "range", "biz": biz, "size": size}).WithError(err).Error()
+		return 0, err
+	}
+	return min, nil
+}
+
+// Ping 测试连接状态，文件关闭后返回错误
+func (p File) Ping(_ context.Context) error {
+	return p.db.View(func(*bolt.Tx) error { return nil })
+}
+
+// Init 创建bucket
+func (p File) Init(dataCenter uint8) error {
+	return p.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(fileBucket))
+		return err
+	})
+}
+
+// BlockDB 本地文件不需要提前创建db
+func (p File) BlockDB(dataCenter uint8, db string) bool { return false }
+
+// Close 关闭文件，释放文件锁
+func (p File) Close() error {
+	return p.db.Close()
+}
+
+// CreateDB 写入/rabbitid/{dc}/{db}作为db的标记，本地文件的Range不检查db
+func (p File) CreateDB(ctx context.Context, dataCenter uint8, db string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(fileBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(fmt.Sprintf("%s/%d/%s", fileRoot, dataCenter, db)), nil)
+	})
+}
+
+// ListDBs 机房下创建过的db和有计数的db
+func (p File) ListDBs(ctx context.Context, dataCenter uint8) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	dbs := make(map[string]bool)
+	err := p.scan(fmt.Sprintf("%s/%d/", fileRoot, dataCenter), func(name string) {
+		dbs[strings.SplitN(name, "/", 2)[0]] = true
+	})
+	return sortedKeys(dbs), err
+}
+
+// ListTables db下有计数的table
+func (p File) ListTables(ctx context.Context, dataCenter uint8, db string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tables := make(map[string]bool)
+	err := p.scan(fmt.Sprintf("%s/%d/%s/", fileRoot, dataCenter, db), func(name string) {
+		tables[name] = true
+	})
+	return sortedKeys(tables), err
+}
+
+// GetCounter 已经分配的最大值
+func (p File) GetCounter(ctx context.Context, dataCenter uint8, db, table string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var v []byte
+	err := p.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(fileBucket)); b != nil {
+			v = b.Get([]byte(fmt.Sprintf(fileTPL, fileRoot, dataCenter, db, table)))
+		}
+		if v == nil {
+			return ErrTableNotExists
+		}
+		// v 只在事务中有效
+		v = append([]byte(nil), v...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(v), 10, 64)
+}
+
+// DeleteTable 删除计数
+func (p File) DeleteTable(ctx context.Context, dataCenter uint8, db, table string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(fileBucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(fmt.Sprintf(fileTPL, fileRoot, dataCenter, db, table)))
+	})
+}
+
+// scan 遍历前缀为prefix的key，fn 的参数为去掉前缀后的部分
+func (p File) scan(prefix string, fn func(name string)) error {
+	return p.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(fileBucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, _ := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = c.Next() {
+			fn(string(k[len(prefix):]))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFile_Range(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rabbitid")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rabbitid.bolt")
+
+	client := NewFile(path, logrus.NewEntry(logrus.New()))
+	assert.NoError(t, client.Init(testDC))
+	n, err := client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, int64(0))
+	assert.NoError(t, client.Close())
+
+	// 重新打开后继续分配
+	client = NewFile(path, logrus.NewEntry(logrus.New()))
+	defer client.Close()
+	n, err = client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, testSize)
+}
+
+func TestFile_Lock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rabbitid")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rabbitid.bolt")
+
+	client := NewFile(path, logrus.NewEntry(logrus.New()))
+	_, err = openFile(path)
+	assert.EqualError(t, err, ErrFileLocked.Error())
+
+	assert.NoError(t, client.Close())
+	db, err := openFile(path)
+	assert.NoError(t, err)
+	db.Close()
+}
+
+func TestFile_Ping(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rabbitid")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	client := NewFile(filepath.Join(dir, "rabbitid.bolt"), logrus.NewEntry(logrus.New()))
+	assert.NoError(t, client.Ping(context.TODO()))
+	client.Close()
+	assert.Error(t, client.Ping(context.TODO()))
+}
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const memoryTPL = "%d/%s/%s"
+
+// A Memory 使用内存存储发号进度，用于单元测试和本地演示，进程退出后数据丢失。
+// 支持注入延迟和错误，模拟存储超时、db不存在等故障
+type Memory struct {
+	mu       sync.Mutex
+	counters map[string]int64
+	// latency 每次Range的延迟，超过ctx的超时时间返回ctx.Err()
+	latency time.Duration
+	// failures, failErr 接下来failures次Range返回failErr
+	failures int
+	failErr  error
+	// disabled 不存在的db，Range返回ErrDBNotExists
+	disabled map[string]bool
+	// dbs 通过CreateDB创建的db
+	dbs map[string]bool
+}
+
+// NewMemory 获取内存存储实例
+func NewMemory() *Memory {
+	return &Memory{
+		counters: make(map[string]int64),
+		disabled: make(map[string]bool),
+		dbs:      make(map[string]bool),
+	}
+}
+
+// Range 分片分配进度, 返回v 表示可用范围(v, v+size]
+func (p *Memory) Range(ctx context.Context, dataCenter uint8, db, table string, size int64) (int64, error) {
+	p.mu.Lock()
+	latency := p.latency
+	p.mu.Unlock()
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return 0, p.failErr
+	}
+	if p.disabled[fmt.Sprintf("%d/%s", dataCenter, db)] {
+		return 0, ErrDBNotExists
+	}
+	biz := fmt.Sprintf(memoryTPL, dataCenter, db, table)
+	min := p.counters[biz]
+	p.counters[biz] = min + size
+	return min, nil
+}
+
+// Bump 计数小于min时设置为min
+func (p *Memory) Bump(ctx context.Context, dataCenter uint8, db, table string, min int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	biz := fmt.Sprintf(memoryTPL, dataCenter, db, table)
+	if p.counters[biz] < min {
+		p.counters[biz] = min
+	}
+	return nil
+}
+
+// Reset 清空所有计数，模拟存储丢失数据
+func (p *Memory) Reset() {
+	p.mu.Lock()
+	p.counters = make(map[string]int64)
+	p.dbs = make(map[string]bool)
+	p.mu.Unlock()
+}
+
+// Ping 检查连接
+func (p *Memory) Ping(_ context.Context) error { return nil }
+
+// Init 这里可以完成初始化方法，保证服务的可用
+func (p *Memory) Init(dataCenter uint8) error { return nil }
+
+// BlockDB 被DisableDB的db跳过加载
+func (p *Memory) BlockDB(dataCenter uint8, db string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.disabled[fmt.Sprintf("%d/%s", dataCenter, db)]
+}
+
+// SetLatency 设置每次Range的延迟，用于模拟存储变慢和超时
+func (p *Memory) SetLatency(d time.Duration) {
+	p.mu.Lock()
+	p.latency = d
+	p.mu.Unlock()
+}
+
+// FailNext 接下来n次Range返回err
+func (p *Memory) FailNext(n int, err error) {
+	p.mu.Lock()
+	p.failures = n
+	p.failErr = err
+	p.mu.Unlock()
+}
+
+// DisableDB 模拟db不存在，Range返回ErrDBNotExists
+func (p *Memory) DisableDB(dataCenter uint8, db string) {
+	p.mu.Lock()
+	p.disabled[fmt.Sprintf("%d/%s", dataCenter, db)] = true
+	p.mu.Unlock()
+}
+
+// EnableDB 恢复被DisableDB的db
+func (p *Memory) EnableDB(dataCenter uint8, db string) {
+	p.mu.Lock()
+	delete(p.disabled, fmt.Sprintf("%d/%s", dataCenter, db))
+	p.mu.Unlock()
+}
+
+// CreateDB 创建db，同时恢复被DisableDB的db
+func (p *Memory) CreateDB(ctx context.Context, dataCenter uint8, db string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dbs[fmt.Sprintf("%d/%s", dataCenter, db)] = true
+	delete(p.disabled, fmt.Sprintf("%d/%s", dataCenter, db))
+	return nil
+}
+
+// ListDBs 创建过的db和有计数的db
+func (p *Memory) ListDBs(ctx context.Context, dataCenter uint8) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	prefix := fmt.Sprintf("%d/", dataCenter)
+	dbs := make(map[string]bool)
+	for k := range p.dbs {
+		if strings.HasPrefix(k, prefix) {
+			dbs[k[len(prefix):]] = true
+		}
+	}
+	for k := range p.counters {
+		if strings.HasPrefix(k, prefix) {
+			dbs[strings.SplitN(k[len(prefix):], "/", 2)[0]] = true
+		}
+	}
+	return sortedKeys(dbs), nil
+}
+
+// ListTables db下有计数的table
+func (p *Memory) ListTables(ctx context.Context, dataCenter uint8, db string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	prefix := fmt.Sprintf("%d/%s/", dataCenter, db)
+	tables := make(map[string]bool)
+	for k := range p.counters {
+		if strings.HasPrefix(k, prefix) {
+			tables[k[len(prefix):]] = true
+		}
+	}
+	return sortedKeys(tables), nil
+}
+
+// GetCounter 已经分配的最大值
+func (p *Memory) GetCounter(ctx context.Context, dataCenter uint8, db, table string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	max, ok := p.counters[fmt.Sprintf(memoryTPL, dataCenter, db, table)]
+	if !ok {
+		return 0, ErrTableNotExists
+	}
+	return max, nil
+}
+
+// DeleteTable 删除计数
+func (p *Memory) DeleteTable(ctx context.Context, dataCenter uint8, db, table string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.counters, fmt.Sprintf(memoryTPL, dataCenter, db, table))
+	return nil
+}
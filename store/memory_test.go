@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory_Range(t *testing.T) {
+	client := NewMemory()
+	n, err := client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, int64(0))
+
+	n, err = client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, testSize)
+}
+
+func TestMemory_Latency(t *testing.T) {
+	client := NewMemory()
+	client.SetLatency(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	_, err := client.Range(ctx, testDC, testDB, testTable, testSize)
+	cancel()
+	assert.EqualError(t, err, context.DeadlineExceeded.Error())
+
+	// 超时的请求不分配号段
+	client.SetLatency(0)
+	n, err := client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, int64(0))
+}
+
+func TestMemory_FailNext(t *testing.T) {
+	client := NewMemory()
+	client.FailNext(2, ErrDBNotExists)
+	for i := 0; i < 2; i++ {
+		_, err := client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+		assert.EqualError(t, err, ErrDBNotExists.Error())
+	}
+	_, err := client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+}
+
+func TestMemory_DisableDB(t *testing.T) {
+	client := NewMemory()
+	client.DisableDB(testDC, testDB)
+	assert.True(t, client.BlockDB(testDC, testDB))
+	_, err := client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.EqualError(t, err, ErrDBNotExists.Error())
+
+	client.EnableDB(testDC, testDB)
+	assert.False(t, client.BlockDB(testDC, testDB))
+	_, err = client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+}
+
+func TestMemory_Bump(t *testing.T) {
+	client := NewMemory()
+	assert.NoError(t, client.Bump(context.TODO(), testDC, testDB, testTable, testSize*2))
+	assert.NoError(t, client.Bump(context.TODO(), testDC, testDB, testTable, testSize))
+	n, err := client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, testSize*2)
+
+	client.Reset()
+	n, err = client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, int64(0))
+}
@@ -0,0 +1,224 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen db连续失败，熔断期间不再访问存储
+var ErrCircuitOpen = errors.New("store circuit open")
+
+// A Middleware 包装存储，增加重试、熔断、超时等通用逻辑，返回的存储需要实现Wrapper
+type Middleware func(Store) Store
+
+// Chain 依次使用中间件包装存储，第一个中间件在最外层
+func Chain(s Store, mws ...Middleware) Store {
+	for i := len(mws) - 1; i >= 0; i-- {
+		s = mws[i](s)
+	}
+	return s
+}
+
+// BumperOf 获取存储的Bumper，包装的存储使用被包装的存储的Bumper
+func BumperOf(s Store) (Bumper, bool) {
+	for s != nil {
+		if b, ok := s.(Bumper); ok {
+			return b, true
+		}
+		w, ok := s.(Wrapper)
+		if !ok {
+			break
+		}
+		s = w.Unwrap()
+	}
+	return nil, false
+}
+
+// permanent 重试也不会成功的错误。单次调用超时可能是存储变慢，调用方的ctx没有结束时继续重试
+func permanent(err error) bool {
+	switch err {
+	case ErrDBNotExists, ErrCircuitOpen, ErrCounterRegression:
+		return true
+	}
+	return false
+}
+
+// A Backoff 第attempt次重试前的等待时间，attempt 从0开始
+type Backoff func(attempt int) time.Duration
+
+// ExponentialBackoff 等待时间从base开始翻倍，最多max，在[d/2, d]中随机，避免多个进程同时重试
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 0; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		if d <= 1 {
+			return d
+		}
+		return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+}
+
+// Retry Range失败后按照backoff等待再重试，最多调用attempts次，
+// db不存在、熔断等错误直接返回。是否结束取决于调用方的ctx，内层Timeout的单次超时会重试
+func Retry(attempts int, backoff Backoff) Middleware {
+	return func(s Store) Store {
+		return retryStore{Store: s, attempts: attempts, backoff: backoff}
+	}
+}
+
+type retryStore struct {
+	Store
+	attempts int
+	backoff  Backoff
+}
+
+// Unwrap 被包装的存储
+func (p retryStore) Unwrap() Store { return p.Store }
+
+// Range 分片分配进度, 返回v 表示可用范围(v, v+size]
+func (p retryStore) Range(ctx context.Context, dataCenter uint8, db, table string, size int64) (int64, error) {
+	for i := 0; ; i++ {
+		min, err := p.Store.Range(ctx, dataCenter, db, table, size)
+		if err == nil || permanent(err) || ctx.Err() != nil || i+1 >= p.attempts {
+			return min, err
+		}
+		timer := time.NewTimer(p.backoff(i))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Timeout 每次Range和Ping最长等待d，调用方的ctx更短时使用调用方的ctx
+func Timeout(d time.Duration) Middleware {
+	return func(s Store) Store {
+		return timeoutStore{Store: s, timeout: d}
+	}
+}
+
+type timeoutStore struct {
+	Store
+	timeout time.Duration
+}
+
+// Unwrap 被包装的存储
+func (p timeoutStore) Unwrap() Store { return p.Store }
+
+// Range 分片分配进度, 返回v 表示可用范围(v, v+size]
+func (p timeoutStore) Range(ctx context.Context, dataCenter uint8, db, table string, size int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return p.Store.Range(ctx, dataCenter, db, table, size)
+}
+
+// Ping 检查连接
+func (p timeoutStore) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return p.Store.Ping(ctx)
+}
+
+// CircuitBreaker 按照db熔断，同一个db连续失败failures次后，cooldown内Range直接返回ErrCircuitOpen，
+// BlockDB返回true，后台任务不再加载。cooldown之后只放行一个请求探测，成功后恢复，失败继续熔断
+func CircuitBreaker(failures int, cooldown time.Duration) Middleware {
+	return func(s Store) Store {
+		return &breakerStore{Store: s, failures: failures, cooldown: cooldown, dbs: make(map[string]*breakerState)}
+	}
+}
+
+type breakerStore struct {
+	Store
+	failures int
+	cooldown time.Duration
+	mu       sync.Mutex
+	dbs      map[string]*breakerState
+}
+
+type breakerState struct {
+	// failures 连续失败次数
+	failures int
+	// openUntil 熔断结束时间
+	openUntil time.Time
+	// probing cooldown之后已经放行了一个请求，结果返回之前其他请求继续熔断
+	probing bool
+}
+
+// Unwrap 被包装的存储
+func (p *breakerStore) Unwrap() Store { return p.Store }
+
+// Range 分片分配进度, 返回v 表示可用范围(v, v+size]
+func (p *breakerStore) Range(ctx context.Context, dataCenter uint8, db, table string, size int64) (int64, error) {
+	key := fmt.Sprintf("%d/%s", dataCenter, db)
+	if !p.allow(key) {
+		return 0, ErrCircuitOpen
+	}
+	min, err := p.Store.Range(ctx, dataCenter, db, table, size)
+	p.record(key, err)
+	return min, err
+}
+
+// BlockDB 熔断中的db跳过加载
+func (p *breakerStore) BlockDB(dataCenter uint8, db string) bool {
+	return p.open(fmt.Sprintf("%d/%s", dataCenter, db)) || p.Store.BlockDB(dataCenter, db)
+}
+
+// open 熔断中或者正在探测，不消耗探测的机会
+func (p *breakerStore) open(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state, ok := p.dbs[key]
+	return ok && (state.probing || time.Now().Before(state.openUntil))
+}
+
+// allow 没有熔断时放行，cooldown之后只放行一个探测请求
+func (p *breakerStore) allow(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state, ok := p.dbs[key]
+	if !ok || state.failures < p.failures {
+		return true
+	}
+	if state.probing || time.Now().Before(state.openUntil) {
+		return false
+	}
+	state.probing = true
+	return true
+}
+
+func (p *breakerStore) record(key string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		delete(p.dbs, key)
+		return
+	}
+	state, ok := p.dbs[key]
+	// 调用方取消不是存储的问题，交给下一个请求探测
+	if err == context.Canceled {
+		if ok {
+			state.probing = false
+		}
+		return
+	}
+	if !ok {
+		state = &breakerState{}
+		p.dbs[key] = state
+	}
+	state.probing = false
+	state.failures++
+	if state.failures >= p.failures {
+		state.openUntil = time.Now().Add(p.cooldown)
+	}
+}
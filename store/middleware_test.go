@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errTestStore = errors.New("store unavailable")
+
+func TestMiddleware_Retry(t *testing.T) {
+	db := NewMemory()
+	client := Chain(db, Retry(3, ExponentialBackoff(time.Millisecond, 2*time.Millisecond)))
+	db.FailNext(2, errTestStore)
+	n, err := client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, int64(0))
+
+	db.FailNext(3, errTestStore)
+	_, err = client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.EqualError(t, err, errTestStore.Error())
+
+	// db不存在不重试
+	db.DisableDB(testDC, testDB)
+	db.FailNext(0, nil)
+	_, err = client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.EqualError(t, err, ErrDBNotExists.Error())
+}
+
+func TestMiddleware_Timeout(t *testing.T) {
+	db := NewMemory()
+	db.SetLatency(time.Second)
+	client := Chain(db, Timeout(10*time.Millisecond))
+	_, err := client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.EqualError(t, err, context.DeadlineExceeded.Error())
+}
+
+func TestMiddleware_RetryTimeout(t *testing.T) {
+	db := NewMemory()
+	db.SetLatency(time.Second)
+	// 第一次调用超时，第二次调用之前存储恢复
+	slow := &hookStore{Store: db, n: 2, hook: func() { db.SetLatency(0) }}
+	client := Chain(slow, Retry(3, ExponentialBackoff(time.Millisecond, 2*time.Millisecond)), Timeout(10*time.Millisecond))
+	n, err := client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, int64(0))
+	assert.Equal(t, slow.calls, int32(2))
+
+	// 调用方的ctx结束后不再重试
+	db.SetLatency(time.Second)
+	slow.calls = 0
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Millisecond)
+	defer cancel()
+	_, err = client.Range(ctx, testDC, testDB, testTable, testSize)
+	assert.EqualError(t, err, context.DeadlineExceeded.Error())
+	assert.Equal(t, slow.calls, int32(1))
+}
+
+func TestMiddleware_CircuitBreaker(t *testing.T) {
+	db := NewMemory()
+	client := Chain(db, CircuitBreaker(2, 20*time.Millisecond))
+	db.FailNext(2, errTestStore)
+	for i := 0; i < 2; i++ {
+		_, err := client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+		assert.EqualError(t, err, errTestStore.Error())
+	}
+	// 熔断中不访问存储，其他db不受影响
+	_, err := client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.EqualError(t, err, ErrCircuitOpen.Error())
+	assert.True(t, client.BlockDB(testDC, testDB))
+	_, err = client.Range(context.TODO(), testDC, "other", testTable, testSize)
+	assert.NoError(t, err)
+
+	time.Sleep(30 * time.Millisecond)
+	assert.False(t, client.BlockDB(testDC, testDB))
+	n, err := client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, int64(0))
+}
+
+func TestMiddleware_CircuitBreakerProbe(t *testing.T) {
+	db := NewMemory()
+	client := Chain(db, CircuitBreaker(1, 10*time.Millisecond))
+	db.FailNext(1, errTestStore)
+	_, err := client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.EqualError(t, err, errTestStore.Error())
+
+	// cooldown之后只放行一个请求，探测返回之前其他请求继续熔断
+	time.Sleep(20 * time.Millisecond)
+	db.SetLatency(50 * time.Millisecond)
+	errs := make([]error, 5)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+		}(i)
+	}
+	wg.Wait()
+	var ok int
+	for _, err := range errs {
+		if err == nil {
+			ok++
+		} else {
+			assert.EqualError(t, err, ErrCircuitOpen.Error())
+		}
+	}
+	assert.Equal(t, ok, 1)
+
+	// 探测成功后恢复
+	db.SetLatency(0)
+	_, err = client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+}
+
+func TestMiddleware_Unwrap(t *testing.T) {
+	db := NewMemory()
+	client := Chain(db, CircuitBreaker(1, time.Second), Retry(2, ExponentialBackoff(0, 0)), Timeout(time.Second))
+	admin, err := AdminOf(client)
+	assert.NoError(t, err)
+	assert.Equal(t, admin, db)
+	b, ok := BumperOf(client)
+	assert.True(t, ok)
+	assert.Equal(t, b, db)
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(10*time.Millisecond, 40*time.Millisecond)
+	for attempt, max := range []time.Duration{10, 20, 40, 40} {
+		max *= time.Millisecond
+		d := backoff(attempt)
+		assert.True(t, d >= max/2 && d <= max, "attempt %d: %s", attempt, d)
+	}
+}
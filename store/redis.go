@@ -2,40 +2,183 @@ package store
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/go-redis/redis"
 	"github.com/sirupsen/logrus"
 )
 
-const redisPrefix = "rabbitid_%d_%s_%s"
+const (
+	// redisPrefix 计数保存的key，集群模式使用相同的key。每个计数只有一个key，HINCRBY、HGET 和拉高的脚本
+	// 都只访问这一个key，不需要hash tag；没有多key操作，加上hash tag只会产生另一种不通用的key
+	redisPrefix = "rabbitid_%d_%s_%s"
+	// redisDBSet 通过CreateDB创建的db集合
+	redisDBSet = "rabbitid_db_%d"
+	// redisScanCount 每次SCAN的数量
+	redisScanCount = 100
+)
+
+// ErrRedisURI redis连接串不合法
+var ErrRedisURI = errors.New("invalid redis uri")
+
+// redisBump 计数小于ARGV[2]时设置为ARGV[2]，在redis中原子执行
+var redisBump = redis.NewScript(`
+local cur = tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0')
+if cur < tonumber(ARGV[2]) then
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+	return 1
+end
+return 0`)
 
 // A Redis 使用redis作存储
 type Redis struct {
-	conn *redis.Client
+	conn redis.UniversalClient
 	log  *logrus.Entry
 }
 
-// NewRedis 获取redis实例
-func NewRedis(redisAddr string, logger *logrus.Entry) Redis {
-	cli := redis.NewClient(&redis.Options{
-		Addr: redisAddr,
-	})
-	_, err := cli.Ping().Result()
+// NewRedis 获取redis实例，支持以下连接串：
+//
+//	host:port
+//	redis://[:password@]host:port[/db]，rediss:// 使用TLS
+//	redis-sentinel://[:password@]host:port[,host:port]/master[/db]，rediss-sentinel:// 使用TLS
+//	redis-cluster://[:password@]host:port[,host:port]，rediss-cluster:// 使用TLS
+func NewRedis(redisURI string, logger *logrus.Entry) (Redis, error) {
+	cli, _, err := newRedisClient(redisURI)
 	if err != nil {
-		log.Fatal("redis connect error", redisAddr)
+		return Redis{}, err
+	}
+	if _, err = cli.Ping().Result(); err != nil {
+		cli.Close()
+		return Redis{}, fmt.Errorf("redis connect error %s: %v", redisURI, err)
+	}
+	return Redis{conn: cli, log: logger.WithField("store", "redis")}, nil
+}
+
+// newRedisClient 根据连接串生成对应的客户端，cluster 表示是否为集群模式
+func newRedisClient(redisURI string) (redis.UniversalClient, bool, error) {
+	scheme := ""
+	if i := strings.Index(redisURI, "://"); i >= 0 {
+		scheme = redisURI[:i]
+	}
+	switch scheme {
+	case "":
+		return redis.NewClient(&redis.Options{Addr: redisURI}), false, nil
+	case "redis", "rediss":
+		opt, err := redis.ParseURL(redisURI)
+		if err != nil {
+			return nil, false, err
+		}
+		return redis.NewClient(opt), false, nil
+	case "redis-sentinel", "rediss-sentinel":
+		u, err := parseRedisURI(redisURI)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(u.path) < 1 || len(u.path) > 2 || u.path[0] == "" {
+			return nil, false, ErrRedisURI
+		}
+		opt := &redis.FailoverOptions{
+			MasterName:    u.path[0],
+			SentinelAddrs: u.addrs,
+			Password:      u.password,
+			TLSConfig:     u.tlsConfig,
+		}
+		if len(u.path) == 2 {
+			if opt.DB, err = strconv.Atoi(u.path[1]); err != nil {
+				return nil, false, ErrRedisURI
+			}
+		}
+		return redis.NewFailoverClient(opt), false, nil
+	case "redis-cluster", "rediss-cluster":
+		u, err := parseRedisURI(redisURI)
+		if err != nil {
+			return nil, false, err
+		}
+		// 集群模式不支持选择db
+		if len(u.path) > 0 {
+			return nil, false, ErrRedisURI
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     u.addrs,
+			Password:  u.password,
+			TLSConfig: u.tlsConfig,
+		}), true, nil
+	}
+	return nil, false, ErrRedisURI
+}
+
+// redisURI 哨兵和集群的连接串，包含多个地址
+type redisURI struct {
+	addrs     []string
+	password  string
+	path      []string
+	tlsConfig *tls.Config
+}
+
+// parseRedisURI 解析 scheme://[:password@]host:port[,host:port][/path]
+// 多个地址无法使用url.Parse解析
+func parseRedisURI(uri string) (redisURI, error) {
+	var u redisURI
+	i := strings.Index(uri, "://")
+	if strings.HasPrefix(uri[:i], "rediss") {
+		u.tlsConfig = &tls.Config{}
+	}
+	rest := uri[i+3:]
+	if j := strings.LastIndex(rest, "@"); j >= 0 {
+		userinfo := rest[:j]
+		if k := strings.Index(userinfo, ":"); k >= 0 {
+			u.password = userinfo[k+1:]
+		}
+		rest = rest[j+1:]
+	}
+	hosts := rest
+	if j := strings.Index(rest, "/"); j >= 0 {
+		hosts = rest[:j]
+		if path := strings.Trim(rest[j:], "/"); path != "" {
+			u.path = strings.Split(path, "/")
+		}
+	}
+	for _, addr := range strings.Split(hosts, ",") {
+		if addr == "" {
+			return u, ErrRedisURI
+		}
+		u.addrs = append(u.addrs, addr)
 	}
-	return Redis{conn: cli, log: logger.WithField("store", "redis")}
+	return u, nil
 }
 
-// Range 分片分配进度, 返回v 表示可用范围[v, v+size)
-func (p Redis) Range(_ context.Context, dataCenter uint8, db, table string, size int64) (int64, error) {
-	biz := fmt.Sprintf(redisPrefix, dataCenter, db, table)
+// key 计数保存的key
+func (p Redis) key(dataCenter uint8, db, table string) string {
+	return fmt.Sprintf(redisPrefix, dataCenter, db, table)
+}
+
+// Range 分片分配进度, 返回v 表示可用范围(v, v+size]
+func (p Redis) Range(ctx context.Context, dataCenter uint8, db, table string, size int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	biz := p.key(dataCenter, db, table)
 	value := p.conn.HIncrBy(biz, table, size)
+	if err := value.Err(); err != nil {
+		return 0, err
+	}
 	max := value.Val()
 	p.log.WithFields(logrus.Fields{"action": "range", "biz": biz, "size": size, "last": max - size})
-	return max - size, value.Err()
+	return max - size, nil
+}
+
+// Bump 计数小于min时设置为min，用于存储丢失数据后恢复计数
+func (p Redis) Bump(ctx context.Context, dataCenter uint8, db, table string, min int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	biz := p.key(dataCenter, db, table)
+	return redisBump.Run(p.conn, []string{biz}, table, min).Err()
 }
 
 // Ping 测试连接状态
@@ -44,7 +187,148 @@ func (p Redis) Ping(_ context.Context) error {
 	return value.Err()
 }
 
+// Close 关闭连接
+func (p Redis) Close() error {
+	return p.conn.Close()
+}
+
 // Init 这里可以完成初始化方法，保证服务的可用
 func (p Redis) Init(dataCenter uint8) error { return nil }
 
 func (p Redis) BlockDB(dataCenter uint8, db string) bool { return false }
+
+// CreateDB 记录db，redis的Range不检查db
+func (p Redis) CreateDB(ctx context.Context, dataCenter uint8, db string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.conn.SAdd(fmt.Sprintf(redisDBSet, dataCenter), db).Err()
+}
+
+// ListDBs 创建过的db和有计数的db，有计数的db通过SCAN查找
+func (p Redis) ListDBs(ctx context.Context, dataCenter uint8) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	created, err := p.conn.SMembers(fmt.Sprintf(redisDBSet, dataCenter)).Result()
+	if err != nil {
+		return nil, err
+	}
+	dbs := make(map[string]bool)
+	for _, db := range created {
+		dbs[db] = true
+	}
+	counters, err := p.counters(ctx, dataCenter, "*")
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range counters {
+		dbs[c[0]] = true
+	}
+	return sortedKeys(dbs), nil
+}
+
+// ListTables db下有计数的table
+func (p Redis) ListTables(ctx context.Context, dataCenter uint8, db string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	counters, err := p.counters(ctx, dataCenter, redisEscape(db))
+	if err != nil {
+		return nil, err
+	}
+	tables := make(map[string]bool)
+	for _, c := range counters {
+		if c[0] == db {
+			tables[c[1]] = true
+		}
+	}
+	return sortedKeys(tables), nil
+}
+
+// GetCounter 已经分配的最大值
+func (p Redis) GetCounter(ctx context.Context, dataCenter uint8, db, table string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	max, err := p.conn.HGet(p.key(dataCenter, db, table), table).Int64()
+	if err == redis.Nil {
+		return 0, ErrTableNotExists
+	}
+	return max, err
+}
+
+// DeleteTable 删除计数
+func (p Redis) DeleteTable(ctx context.Context, dataCenter uint8, db, table string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.conn.HDel(p.key(dataCenter, db, table), table).Err()
+}
+
+// counters 查找匹配db的所有计数，返回[db, table]。
+// db和table中都可能有"_"，通过hash的field还原table，再从key中还原db
+func (p Redis) counters(ctx context.Context, dataCenter uint8, dbPattern string) ([][2]string, error) {
+	prefix := fmt.Sprintf("rabbitid_%d_", dataCenter)
+	keys, err := p.scan(fmt.Sprintf(redisPrefix, dataCenter, dbPattern, "*"))
+	if err != nil {
+		return nil, err
+	}
+	var counters [][2]string
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fields, err := p.conn.HKeys(key).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, table := range fields {
+			db := strings.TrimPrefix(key, prefix)
+			db = strings.TrimSuffix(db, "_"+table)
+			if p.key(dataCenter, db, table) == key {
+				counters = append(counters, [2]string{db, table})
+			}
+		}
+	}
+	return counters, nil
+}
+
+// scan 查找所有匹配的key，集群模式下需要遍历每个master
+func (p Redis) scan(match string) ([]string, error) {
+	cluster, ok := p.conn.(*redis.ClusterClient)
+	if !ok {
+		return redisScan(p.conn, match)
+	}
+	var mu sync.Mutex
+	var keys []string
+	err := cluster.ForEachMaster(func(c *redis.Client) error {
+		got, err := redisScan(c, match)
+		mu.Lock()
+		keys = append(keys, got...)
+		mu.Unlock()
+		return err
+	})
+	return keys, err
+}
+
+func redisScan(c redis.Cmdable, match string) ([]string, error) {
+	var keys []string
+	iter := c.Scan(0, match, redisScanCount).Iterator()
+	for iter.Next() {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+// redisEscape 转义SCAN MATCH中的通配符
+func redisEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
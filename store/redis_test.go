@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/go-redis/redis"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
@@ -15,7 +16,8 @@ const (
 
 func TestRedis_Range(t *testing.T) {
 	log := logrus.NewEntry(logrus.New())
-	client := NewRedis(testRedis, log)
+	client, err := NewRedis(testRedis, log)
+	assert.NoError(t, err)
 
 	// 清理旧数据
 	biz := fmt.Sprintf(redisPrefix, testDC, testDB, testTable)
@@ -29,17 +31,80 @@ func TestRedis_Range(t *testing.T) {
 	assert.Equal(t, n, int64(0))
 
 	ctx, cancel = context.WithTimeout(context.Background(), timeout)
-	cancel()
 	n, err = client.Range(ctx, testDC, testDB, testTable, testSize)
+	cancel()
 	assert.NoError(t, err)
 	assert.Equal(t, n, testSize)
 }
 
 func TestRedis_Ping(t *testing.T) {
 	log := logrus.NewEntry(logrus.New())
-	client := NewRedis(testRedis, log)
+	client, err := NewRedis(testRedis, log)
+	assert.NoError(t, err)
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	err := client.Ping(ctx)
+	err = client.Ping(ctx)
 	cancel()
 	assert.NoError(t, err)
 }
+
+func TestRedis_Bump(t *testing.T) {
+	log := logrus.NewEntry(logrus.New())
+	client, err := NewRedis(testRedis, log)
+	assert.NoError(t, err)
+	biz := fmt.Sprintf(redisPrefix, testDC, testDB, testTable)
+	assert.NoError(t, client.conn.Del(biz).Err())
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	assert.NoError(t, client.Bump(ctx, testDC, testDB, testTable, testSize*2))
+	// 只增不减
+	assert.NoError(t, client.Bump(ctx, testDC, testDB, testTable, testSize))
+	n, err := client.Range(ctx, testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, testSize*2)
+}
+
+func TestParseRedisURI(t *testing.T) {
+	u, err := parseRedisURI("rediss-sentinel://:secret@10.0.0.1:26379,10.0.0.2:26379/mymaster/2")
+	assert.NoError(t, err)
+	assert.Equal(t, u.addrs, []string{"10.0.0.1:26379", "10.0.0.2:26379"})
+	assert.Equal(t, u.password, "secret")
+	assert.Equal(t, u.path, []string{"mymaster", "2"})
+	assert.NotNil(t, u.tlsConfig)
+
+	u, err = parseRedisURI("redis-cluster://10.0.0.1:7000,10.0.0.2:7000")
+	assert.NoError(t, err)
+	assert.Equal(t, u.addrs, []string{"10.0.0.1:7000", "10.0.0.2:7000"})
+	assert.Equal(t, u.password, "")
+	assert.Nil(t, u.path)
+	assert.Nil(t, u.tlsConfig)
+
+	_, err = parseRedisURI("redis-cluster://10.0.0.1:7000,")
+	assert.EqualError(t, err, ErrRedisURI.Error())
+}
+
+func TestNewRedisClient(t *testing.T) {
+	cli, cluster, err := newRedisClient("127.0.0.1:6379")
+	assert.NoError(t, err)
+	assert.False(t, cluster)
+	assert.IsType(t, cli, &redis.Client{})
+
+	cli, cluster, err = newRedisClient("redis://:secret@127.0.0.1:6379/3")
+	assert.NoError(t, err)
+	assert.False(t, cluster)
+	assert.Equal(t, cli.(*redis.Client).Options().DB, 3)
+
+	cli, cluster, err = newRedisClient("redis-cluster://127.0.0.1:7000,127.0.0.1:7001")
+	assert.NoError(t, err)
+	assert.True(t, cluster)
+	assert.IsType(t, cli, &redis.ClusterClient{})
+	// 集群模式和单机使用相同的key
+	assert.Equal(t, Redis{conn: cli}.key(testDC, testDB, testTable), "rabbitid_0_test_test_1")
+
+	_, _, err = newRedisClient("redis-sentinel://127.0.0.1:26379")
+	assert.EqualError(t, err, ErrRedisURI.Error())
+	_, _, err = newRedisClient("redis-cluster://127.0.0.1:7000/1")
+	assert.EqualError(t, err, ErrRedisURI.Error())
+	_, _, err = newRedisClient("http://127.0.0.1:6379")
+	assert.EqualError(t, err, ErrRedisURI.Error())
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A Factory 根据连接串生成存储，不需要调用Init，NewStore 负责初始化和使用中间件包装。
+// uri 为NewStore收到的连接串，可能带有scheme，例如 etcd://127.0.0.1:2379
+type Factory func(uri string, logger *logrus.Entry, opts ...StoreOption) (Store, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register 注册存储类型，name 同时作为连接串的scheme，例如注册 "tikv" 后，
+// NewStore("tikv", ...) 和 NewStore("", "tikv://...") 都使用该Factory。
+// 一般在存储所在包的init中调用，重复注册或者factory为nil时panic
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if factory == nil {
+		panic("store: Register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("store: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// Types 已经注册的存储类型，按名称排序
+func Types() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	types := make([]string, 0, len(factories))
+	for name := range factories {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func lookup(name string) (Factory, bool) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	f, ok := factories[name]
+	return f, ok
+}
+
+// scheme 连接串的scheme，没有时返回空
+func scheme(uri string) string {
+	if i := strings.Index(uri, "://"); i > 0 {
+		return uri[:i]
+	}
+	return ""
+}
+
+// trimScheme 去掉连接串中的 scheme://，用于不支持URL的存储，例如 etcd://host:port 变为 host:port
+func trimScheme(uri, name string) string {
+	return strings.TrimPrefix(uri, name+"://")
+}
+
+func init() {
+	for _, name := range []string{"redis", "rediss", "redis-sentinel", "rediss-sentinel", "redis-cluster", "rediss-cluster"} {
+		Register(name, func(uri string, logger *logrus.Entry, _ ...StoreOption) (Store, error) {
+			return NewRedis(uri, logger)
+		})
+	}
+	Register("etcd", func(uri string, logger *logrus.Entry, opts ...StoreOption) (Store, error) {
+		return dialEtcd(trimScheme(uri, "etcd"), logger.WithField("store", "etcd"), newStoreOptions(opts).etcd...)
+	})
+	Register("zk", func(uri string, logger *logrus.Entry, opts ...StoreOption) (Store, error) {
+		return dialZK(trimScheme(uri, "zk"), logger.WithField("store", "zk"), newStoreOptions(opts).zk...)
+	})
+	Register("memory", func(string, *logrus.Entry, ...StoreOption) (Store, error) {
+		return NewMemory(), nil
+	})
+	Register("file", func(uri string, logger *logrus.Entry, _ ...StoreOption) (Store, error) {
+		return newFile(trimScheme(uri, "file"), logger)
+	})
+	Register("mysql", func(uri string, logger *logrus.Entry, _ ...StoreOption) (Store, error) {
+		return openSQL("mysql", trimScheme(uri, "mysql"), logger)
+	})
+	// postgres 的连接串本身就是 postgres:// 开头，不需要去掉scheme
+	Register("postgres", func(uri string, logger *logrus.Entry, _ ...StoreOption) (Store, error) {
+		return openSQL("postgres", uri, logger)
+	})
+	Register("sqlite", func(uri string, logger *logrus.Entry, _ ...StoreOption) (Store, error) {
+		return openSQL("sqlite", trimScheme(uri, "sqlite"), logger)
+	})
+	Register("replicated", openReplicated)
+}
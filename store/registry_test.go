@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_Register(t *testing.T) {
+	var got string
+	Register("registry-test", func(uri string, logger *logrus.Entry, opts ...StoreOption) (Store, error) {
+		got = uri
+		return NewMemory(), nil
+	})
+	assert.Contains(t, Types(), "registry-test")
+	assert.Panics(t, func() {
+		Register("registry-test", func(string, *logrus.Entry, ...StoreOption) (Store, error) { return nil, nil })
+	})
+	assert.Panics(t, func() { Register("registry-nil", nil) })
+
+	// 类型为空时使用scheme
+	log := logrus.NewEntry(logrus.New())
+	_, err := NewStore("", "registry-test://a/b", testDC, log)
+	assert.NoError(t, err)
+	assert.Equal(t, got, "registry-test://a/b")
+	_, err = NewStore("registry-test", "c", testDC, log)
+	assert.NoError(t, err)
+	assert.Equal(t, got, "c")
+
+	_, err = NewStore("", "unknown://a", testDC, log)
+	assert.Equal(t, errors.Cause(err), ErrUnknownStore)
+	_, err = NewStore("", "127.0.0.1:6379", testDC, log)
+	assert.Equal(t, errors.Cause(err), ErrUnknownStore)
+}
+
+// initStore 记录Init的次数
+type initStore struct {
+	*Memory
+	inits int
+}
+
+func (p *initStore) Init(dataCenter uint8) error {
+	p.inits++
+	return nil
+}
+
+func TestRegistry_WithoutInit(t *testing.T) {
+	s := &initStore{Memory: NewMemory()}
+	Register("registry-init", func(string, *logrus.Entry, ...StoreOption) (Store, error) { return s, nil })
+	log := logrus.NewEntry(logrus.New())
+
+	_, err := NewStore("registry-init", "", testDC, log, WithoutInit())
+	assert.NoError(t, err)
+	assert.Equal(t, s.inits, 0)
+	_, err = NewStore("registry-init", "", testDC, log)
+	assert.NoError(t, err)
+	assert.Equal(t, s.inits, 1)
+}
+
+func TestRegistry_Scheme(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rabbitid")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	log := logrus.NewEntry(logrus.New())
+
+	path := filepath.Join(dir, "rabbitid.bolt")
+	s, err := NewStore("", "file://"+path, testDC, log)
+	assert.NoError(t, err)
+	n, err := s.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, int64(0))
+	// 出错时返回错误，不退出进程
+	_, err = NewStore("file", path, testDC, log)
+	assert.Equal(t, errors.Cause(err), ErrFileLocked)
+	s.(File).Close()
+
+	s, err = NewStore("", "replicated://quorum=2;memory=;memory=", testDC, log, WithMiddleware(Retry(2, ExponentialBackoff(0, 0))))
+	assert.NoError(t, err)
+	r, ok := s.(*Replicated)
+	assert.True(t, ok)
+	assert.Equal(t, len(r.stores), 2)
+	assert.Equal(t, r.quorum, 2)
+	// 副本分别使用中间件包装
+	_, ok = r.stores[0].(retryStore)
+	assert.True(t, ok)
+}
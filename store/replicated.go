@@ -0,0 +1,372 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	replicatedTPL = "%d/%s/%s"
+	// replicatedAttempts 其他进程同时分配导致不足quorum时重新分配的次数
+	replicatedAttempts = 3
+)
+
+var (
+	// ErrQuorum 成功的副本数量少于quorum
+	ErrQuorum = errors.New("replicated store: quorum not reached")
+	// ErrReplicatedURI replicated连接串不合法
+	ErrReplicatedURI = errors.New("invalid replicated uri")
+	// errReplicatedConflict 落后的副本补齐时其他进程也在分配，两次分配的区间不连续
+	errReplicatedConflict = errors.New("replicated store: concurrent range on lagging replica")
+)
+
+// A Replicated 在多个存储上同时分配号段，用于单个存储不可靠的table。
+// 每个副本的Range是原子的，分配到的区间只属于本次调用。Range 返回的区间必须包含在至少quorum个副本
+// 分配给本次调用的区间中，任意两个quorum至少有一个相同的副本，多个进程同时分配也不会返回重复的区间
+type Replicated struct {
+	stores []Store
+	quorum int
+	// locks 同一个table的Range串行执行，保证进程内所有副本分配的顺序一致，不浪费号段
+	locks sync.Map
+	log   *logrus.Entry
+}
+
+// ReplicatedOption Replicated的可选配置
+type ReplicatedOption func(*Replicated) error
+
+// ReplicatedQuorum 需要成功的副本数量，默认为多数派 n/2+1，等于副本数量时需要全部成功
+func ReplicatedQuorum(quorum int) ReplicatedOption {
+	return func(p *Replicated) error {
+		if quorum <= len(p.stores)/2 || quorum > len(p.stores) {
+			return fmt.Errorf("invalid quorum %d of %d stores (majority at least)", quorum, len(p.stores))
+		}
+		p.quorum = quorum
+		return nil
+	}
+}
+
+// NewReplicated 使用多个存储作为副本，quorum 必须是多数派
+func NewReplicated(stores []Store, logger *logrus.Entry, opts ...ReplicatedOption) (*Replicated, error) {
+	if len(stores) == 0 {
+		return nil, ErrReplicatedURI
+	}
+	p := &Replicated{stores: stores, quorum: len(stores)/2 + 1, log: logger.WithField("store", "replicated")}
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// openReplicated replicated的Factory，uri 为 [replicated://][quorum=N;]type=uri;type=uri，
+// 每个副本分别使用中间件包装，熔断和超时只影响单个副本
+func openReplicated(uri string, logger *logrus.Entry, opts ...StoreOption) (Store, error) {
+	quorum, types, uris, err := parseReplicatedURI(trimScheme(uri, "replicated"))
+	if err != nil {
+		return nil, err
+	}
+	middleware := newStoreOptions(opts).middleware
+	stores := make([]Store, len(types))
+	for i := range types {
+		s, err := openStore(types[i], uris[i], logger, opts...)
+		if err != nil {
+			return nil, err
+		}
+		stores[i] = Chain(s, middleware...)
+	}
+	var ropts []ReplicatedOption
+	if quorum > 0 {
+		ropts = append(ropts, ReplicatedQuorum(quorum))
+	}
+	p, err := NewReplicated(stores, logger, ropts...)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// parseReplicatedURI 解析 [quorum=N;]type=uri;type=uri，例如 redis=127.0.0.1:6379;etcd=127.0.0.1:2379
+func parseReplicatedURI(uri string) (quorum int, types, uris []string, err error) {
+	for _, part := range strings.Split(uri, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[0] == "replicated" {
+			return 0, nil, nil, ErrReplicatedURI
+		}
+		if kv[0] == "quorum" {
+			if quorum, err = strconv.Atoi(kv[1]); err != nil {
+				return 0, nil, nil, ErrReplicatedURI
+			}
+			continue
+		}
+		types = append(types, kv[0])
+		uris = append(uris, kv[1])
+	}
+	if len(types) == 0 {
+		return 0, nil, nil, ErrReplicatedURI
+	}
+	return quorum, types, uris, nil
+}
+
+// each 在所有副本上并发执行fn，返回每个副本的错误
+func (p *Replicated) each(fn func(i int, s Store) error) []error {
+	errs := make([]error, len(p.stores))
+	var wg sync.WaitGroup
+	for i, s := range p.stores {
+		wg.Add(1)
+		go func(i int, s Store) {
+			defer wg.Done()
+			errs[i] = fn(i, s)
+		}(i, s)
+	}
+	wg.Wait()
+	return errs
+}
+
+// check 成功的数量达到quorum返回nil，否则返回第一个错误
+func (p *Replicated) check(errs []error) error {
+	var ok int
+	var first error
+	for _, err := range errs {
+		if err == nil {
+			ok++
+		} else if first == nil {
+			first = err
+		}
+	}
+	if ok >= p.quorum {
+		return nil
+	}
+	// 所有副本的错误相同时直接返回，例如ErrDBNotExists、ctx.Err()
+	for _, err := range errs {
+		if err != first {
+			return fmt.Errorf("%v: %d of %d, %v", ErrQuorum, ok, p.quorum, first)
+		}
+	}
+	return first
+}
+
+// Range 分片分配进度, 返回v 表示可用范围(v, v+size]。
+// 其他进程同时分配导致不足quorum时重新分配，之前分配的号段跳过不用
+func (p *Replicated) Range(ctx context.Context, dataCenter uint8, db, table string, size int64) (int64, error) {
+	lock, _ := p.locks.LoadOrStore(fmt.Sprintf(replicatedTPL, dataCenter, db, table), new(sync.Mutex))
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	for i := 0; i < replicatedAttempts; i++ {
+		min, err := p.rangeOnce(ctx, dataCenter, db, table, size)
+		if err != errReplicatedConflict {
+			return min, err
+		}
+		p.log.WithFields(logrus.Fields{"action": "range", "db": db, "table": table, "attempt": i}).Warn(err.Error())
+	}
+	return 0, fmt.Errorf("%v: %v", ErrQuorum, errReplicatedConflict)
+}
+
+// rangeOnce 先在所有副本上分配size，取最大的起始值min，起始值等于min的副本已经分配到(min, min+size]。
+// 落后的副本再分配min-mins[i]，返回的起始值等于第一次分配的结束值时，两次分配连续，
+// 该副本分配到的区间(mins[i], min+size]包含(min, min+size]；不连续说明其他进程在两次分配之间分配过，不能使用
+func (p *Replicated) rangeOnce(ctx context.Context, dataCenter uint8, db, table string, size int64) (int64, error) {
+	mins := make([]int64, len(p.stores))
+	rangeErrs := p.each(func(i int, s Store) error {
+		var err error
+		mins[i], err = s.Range(ctx, dataCenter, db, table, size)
+		return err
+	})
+	if err := p.check(rangeErrs); err != nil {
+		return 0, err
+	}
+	var min int64
+	for i, err := range rangeErrs {
+		if err == nil && mins[i] > min {
+			min = mins[i]
+		}
+	}
+	// 补齐落后的副本，没有成功的副本可能已经不可用，跳过
+	errs := p.each(func(i int, s Store) error {
+		if rangeErrs[i] != nil {
+			return rangeErrs[i]
+		}
+		if mins[i] == min {
+			return nil
+		}
+		cur, err := s.Range(ctx, dataCenter, db, table, min-mins[i])
+		if err == nil && cur != mins[i]+size {
+			err = errReplicatedConflict
+		}
+		if err != nil {
+			p.log.WithFields(logrus.Fields{
+				"action": "repair",
+				"db":     db,
+				"table":  table,
+				"lag":    min - mins[i],
+			}).WithError(err).Error()
+		}
+		return err
+	})
+	if err := p.check(errs); err != nil {
+		for _, e := range errs {
+			if e == errReplicatedConflict {
+				return 0, errReplicatedConflict
+			}
+		}
+		return 0, err
+	}
+	return min, nil
+}
+
+// Bump 把所有副本拉高到min
+func (p *Replicated) Bump(ctx context.Context, dataCenter uint8, db, table string, min int64) error {
+	return p.check(p.each(func(_ int, s Store) error {
+		return Raise(ctx, s, dataCenter, db, table, min)
+	}))
+}
+
+// Init 初始化所有副本
+func (p *Replicated) Init(dataCenter uint8) error {
+	return p.check(p.each(func(_ int, s Store) error {
+		return s.Init(dataCenter)
+	}))
+}
+
+// Close 关闭所有副本，返回第一个错误
+func (p *Replicated) Close() error {
+	var first error
+	for _, s := range p.stores {
+		if err := Close(s); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// BlockDB 可以加载的副本少于quorum时跳过加载
+func (p *Replicated) BlockDB(dataCenter uint8, db string) bool {
+	var ok int
+	for _, s := range p.stores {
+		if !s.BlockDB(dataCenter, db) {
+			ok++
+		}
+	}
+	return ok < p.quorum
+}
+
+// Ping 检查连接，可用的副本少于quorum时返回错误
+func (p *Replicated) Ping(ctx context.Context) error {
+	return p.check(p.each(func(_ int, s Store) error {
+		return s.Ping(ctx)
+	}))
+}
+
+// admins 所有副本的管理接口，有副本不支持时返回ErrAdminNotSupported
+func (p *Replicated) admins() ([]Admin, error) {
+	admins := make([]Admin, len(p.stores))
+	for i, s := range p.stores {
+		a, err := AdminOf(s)
+		if err != nil {
+			return nil, err
+		}
+		admins[i] = a
+	}
+	return admins, nil
+}
+
+// eachAdmin 在所有副本的管理接口上并发执行fn
+func (p *Replicated) eachAdmin(fn func(i int, a Admin) error) error {
+	admins, err := p.admins()
+	if err != nil {
+		return err
+	}
+	return p.check(p.each(func(i int, _ Store) error {
+		return fn(i, admins[i])
+	}))
+}
+
+// CreateDB 在所有副本上创建db
+func (p *Replicated) CreateDB(ctx context.Context, dataCenter uint8, db string) error {
+	return p.eachAdmin(func(_ int, a Admin) error {
+		return a.CreateDB(ctx, dataCenter, db)
+	})
+}
+
+// ListDBs 所有成功的副本中db的并集
+func (p *Replicated) ListDBs(ctx context.Context, dataCenter uint8) ([]string, error) {
+	return p.union(func(a Admin) ([]string, error) {
+		return a.ListDBs(ctx, dataCenter)
+	})
+}
+
+// ListTables 所有成功的副本中table的并集
+func (p *Replicated) ListTables(ctx context.Context, dataCenter uint8, db string) ([]string, error) {
+	return p.union(func(a Admin) ([]string, error) {
+		return a.ListTables(ctx, dataCenter, db)
+	})
+}
+
+func (p *Replicated) union(fn func(a Admin) ([]string, error)) ([]string, error) {
+	lists := make([][]string, len(p.stores))
+	err := p.eachAdmin(func(i int, a Admin) error {
+		var err error
+		lists[i], err = fn(a)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, list := range lists {
+		for _, name := range list {
+			names[name] = true
+		}
+	}
+	return sortedKeys(names), nil
+}
+
+// GetCounter 所有成功的副本中最大的计数，和Range的结果一致
+func (p *Replicated) GetCounter(ctx context.Context, dataCenter uint8, db, table string) (int64, error) {
+	counters := make([]int64, len(p.stores))
+	exists := make([]bool, len(p.stores))
+	err := p.eachAdmin(func(i int, a Admin) error {
+		var err error
+		counters[i], err = a.GetCounter(ctx, dataCenter, db, table)
+		if err == ErrTableNotExists {
+			return nil
+		}
+		exists[i] = err == nil
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	var max int64
+	var found bool
+	for i := range counters {
+		if exists[i] {
+			found = true
+			if counters[i] > max {
+				max = counters[i]
+			}
+		}
+	}
+	if !found {
+		return 0, ErrTableNotExists
+	}
+	return max, nil
+}
+
+// DeleteTable 在所有副本上删除计数
+func (p *Replicated) DeleteTable(ctx context.Context, dataCenter uint8, db, table string) error {
+	return p.eachAdmin(func(_ int, a Admin) error {
+		return a.DeleteTable(ctx, dataCenter, db, table)
+	})
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestReplicated(t *testing.T, n int, opts ...ReplicatedOption) (*Replicated, []*Memory) {
+	memories := make([]*Memory, n)
+	stores := make([]Store, n)
+	for i := range memories {
+		memories[i] = NewMemory()
+		stores[i] = memories[i]
+	}
+	client, err := NewReplicated(stores, logrus.NewEntry(logrus.New()), opts...)
+	assert.NoError(t, err)
+	return client, memories
+}
+
+func TestReplicated_Repair(t *testing.T) {
+	client, memories := newTestReplicated(t, 3)
+	ctx := context.TODO()
+	// 副本0领先，其他副本落后
+	_, err := memories[0].Range(ctx, testDC, testDB, testTable, testSize*3)
+	assert.NoError(t, err)
+
+	n, err := client.Range(ctx, testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, testSize*3)
+	for _, m := range memories {
+		max, err := m.GetCounter(ctx, testDC, testDB, testTable)
+		assert.NoError(t, err)
+		assert.Equal(t, max, testSize*4)
+	}
+}
+
+func TestReplicated_Quorum(t *testing.T) {
+	client, memories := newTestReplicated(t, 3)
+	ctx := context.TODO()
+	failErr := errors.New("fail")
+
+	// 一个副本失败，多数派成功
+	memories[2].FailNext(1, failErr)
+	n, err := client.Range(ctx, testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, int64(0))
+
+	// 之前失败的副本恢复后被拉高，不会返回重复的区间
+	memories[1].FailNext(1, failErr)
+	n, err = client.Range(ctx, testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, testSize)
+	max, err := memories[2].GetCounter(ctx, testDC, testDB, testTable)
+	assert.NoError(t, err)
+	assert.Equal(t, max, testSize*2)
+
+	// 少于quorum
+	memories[0].FailNext(1, failErr)
+	memories[1].FailNext(1, failErr)
+	_, err = client.Range(ctx, testDC, testDB, testTable, testSize)
+	assert.Error(t, err)
+
+	// 所有副本相同的错误直接返回
+	for _, m := range memories {
+		m.DisableDB(testDC, testDB)
+	}
+	assert.True(t, client.BlockDB(testDC, testDB))
+	_, err = client.Range(ctx, testDC, testDB, testTable, testSize)
+	assert.Equal(t, err, ErrDBNotExists)
+
+	// 需要所有副本成功
+	client, memories = newTestReplicated(t, 3, ReplicatedQuorum(3))
+	memories[0].FailNext(1, failErr)
+	_, err = client.Range(ctx, testDC, testDB, testTable, testSize)
+	assert.Error(t, err)
+
+	_, err = NewReplicated([]Store{NewMemory(), NewMemory()}, logrus.NewEntry(logrus.New()), ReplicatedQuorum(1))
+	assert.Error(t, err)
+}
+
+// hookStore 第n次Range之前执行hook，用于构造多个进程交错执行的顺序
+type hookStore struct {
+	Store
+	calls int32
+	n     int32
+	hook  func()
+}
+
+func (p *hookStore) Range(ctx context.Context, dataCenter uint8, db, table string, size int64) (int64, error) {
+	if atomic.AddInt32(&p.calls, 1) == p.n {
+		p.hook()
+	}
+	return p.Store.Range(ctx, dataCenter, db, table, size)
+}
+
+func TestReplicated_Concurrent(t *testing.T) {
+	ctx := context.TODO()
+	log := logrus.NewEntry(logrus.New())
+	failErr := errors.New("fail")
+	memories := []*Memory{NewMemory(), NewMemory(), NewMemory()}
+	// 副本0和1的计数为size，副本2落后
+	for _, m := range memories[:2] {
+		_, err := m.Range(ctx, testDC, testDB, testTable, testSize)
+		assert.NoError(t, err)
+	}
+
+	// 两个进程共用副本。进程A补齐副本2之前，进程B在副本0和2上分配
+	b, err := NewReplicated([]Store{memories[0], memories[1], memories[2]}, log)
+	assert.NoError(t, err)
+	var nb int64
+	hook := &hookStore{Store: memories[2], n: 2, hook: func() {
+		memories[1].FailNext(1, failErr)
+		nb, err = b.Range(ctx, testDC, testDB, testTable, testSize)
+		assert.NoError(t, err)
+	}}
+	a, err := NewReplicated([]Store{memories[0], memories[1], hook}, log)
+	assert.NoError(t, err)
+	memories[0].FailNext(1, failErr)
+	na, err := a.Range(ctx, testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+
+	assert.Equal(t, nb, testSize)
+	// A 补齐副本2时发现B分配过，重新分配
+	assert.True(t, na >= nb+testSize || nb >= na+testSize, "overlap %d %d", na, nb)
+}
+
+func TestReplicated_ParseURI(t *testing.T) {
+	quorum, types, uris, err := parseReplicatedURI("quorum=3;redis=redis://:pass@127.0.0.1:6379/0?x=1; zk=127.0.0.1:2181,127.0.0.2:2181;etcd=127.0.0.1:2379")
+	assert.NoError(t, err)
+	assert.Equal(t, quorum, 3)
+	assert.Equal(t, types, []string{"redis", "zk", "etcd"})
+	assert.Equal(t, uris, []string{"redis://:pass@127.0.0.1:6379/0?x=1", "127.0.0.1:2181,127.0.0.2:2181", "127.0.0.1:2379"})
+
+	for _, uri := range []string{"", "redis", "quorum=a;redis=x", "replicated=memory=", "quorum=1"} {
+		_, _, _, err = parseReplicatedURI(uri)
+		assert.Equal(t, err, ErrReplicatedURI, uri)
+	}
+}
@@ -0,0 +1,215 @@
+package store
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// 快照的格式
+const (
+	SnapshotJSON = "json"
+	SnapshotCSV  = "csv"
+)
+
+// ErrSnapshotFormat 不支持的快照格式或者快照内容错误
+var ErrSnapshotFormat = errors.New("snapshot format error")
+
+// snapshotHeader csv快照的表头
+var snapshotHeader = []string{"dc", "db", "table", "value"}
+
+// A Counter 一个table已经分配的最大值，和Admin.GetCounter一致
+type Counter struct {
+	DataCenter uint8  `json:"dc"`
+	DB         string `json:"db"`
+	Table      string `json:"table"`
+	Value      int64  `json:"value"`
+}
+
+// A CounterDiff 两个存储中同一个table的计数，不存在的计数为0
+type CounterDiff struct {
+	DB    string `json:"db"`
+	Table string `json:"table"`
+	From  int64  `json:"from"`
+	To    int64  `json:"to"`
+}
+
+// ImportResult 导入的结果，Raised 拉高了计数的table数量，Skipped 计数已经足够大跳过的数量
+type ImportResult struct {
+	Raised  int `json:"raised"`
+	Skipped int `json:"skipped"`
+}
+
+// Export 导出机房下所有table的计数，按照db和table排序，存储需要支持Admin
+func Export(ctx context.Context, s Store, dataCenter uint8) ([]Counter, error) {
+	admin, err := AdminOf(s)
+	if err != nil {
+		return nil, err
+	}
+	dbs, err := admin.ListDBs(ctx, dataCenter)
+	if err != nil {
+		return nil, err
+	}
+	var counters []Counter
+	for _, db := range dbs {
+		tables, err := admin.ListTables(ctx, dataCenter, db)
+		if err != nil {
+			return nil, err
+		}
+		for _, table := range tables {
+			value, err := admin.GetCounter(ctx, dataCenter, db, table)
+			// 列出之后被删除
+			if err == ErrTableNotExists {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			counters = append(counters, Counter{DataCenter: dataCenter, DB: db, Table: table, Value: value})
+		}
+	}
+	return counters, nil
+}
+
+// Import 把计数导入存储，只增不减：存储中的计数小于Value+margin时拉高到Value+margin，否则跳过。
+// 存储支持Admin时先创建db并读取当前计数，不支持时直接Raise
+func Import(ctx context.Context, s Store, counters []Counter, margin int64) (ImportResult, error) {
+	var result ImportResult
+	admin, adminErr := AdminOf(s)
+	created := make(map[string]bool)
+	for _, c := range counters {
+		min := c.Value + margin
+		if adminErr == nil {
+			cur, err := admin.GetCounter(ctx, c.DataCenter, c.DB, c.Table)
+			if err != nil && err != ErrTableNotExists {
+				return result, err
+			}
+			if cur >= min {
+				result.Skipped++
+				continue
+			}
+			key := fmt.Sprintf("%d/%s", c.DataCenter, c.DB)
+			if !created[key] {
+				if err = admin.CreateDB(ctx, c.DataCenter, c.DB); err != nil {
+					return result, err
+				}
+				created[key] = true
+			}
+		}
+		if err := Raise(ctx, s, c.DataCenter, c.DB, c.Table, min); err != nil {
+			return result, err
+		}
+		result.Raised++
+	}
+	return result, nil
+}
+
+// Diff 比较两个存储中机房下所有table的计数，只返回不相同的table，按照db和table排序
+func Diff(ctx context.Context, from, to Store, dataCenter uint8) ([]CounterDiff, error) {
+	a, err := Export(ctx, from, dataCenter)
+	if err != nil {
+		return nil, err
+	}
+	b, err := Export(ctx, to, dataCenter)
+	if err != nil {
+		return nil, err
+	}
+	// 两边都已经排序，合并比较
+	var diffs []CounterDiff
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		var d CounterDiff
+		switch {
+		case j >= len(b) || i < len(a) && counterLess(a[i], b[j]):
+			d = CounterDiff{DB: a[i].DB, Table: a[i].Table, From: a[i].Value}
+			i++
+		case i >= len(a) || counterLess(b[j], a[i]):
+			d = CounterDiff{DB: b[j].DB, Table: b[j].Table, To: b[j].Value}
+			j++
+		default:
+			d = CounterDiff{DB: a[i].DB, Table: a[i].Table, From: a[i].Value, To: b[j].Value}
+			i++
+			j++
+		}
+		if d.From != d.To {
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs, nil
+}
+
+func counterLess(a, b Counter) bool {
+	if a.DB != b.DB {
+		return a.DB < b.DB
+	}
+	return a.Table < b.Table
+}
+
+// WriteSnapshot 按照format写入快照，json 为Counter数组，csv 第一行为表头dc,db,table,value
+func WriteSnapshot(w io.Writer, format string, counters []Counter) error {
+	switch format {
+	case SnapshotJSON:
+		if counters == nil {
+			counters = []Counter{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(counters)
+	case SnapshotCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(snapshotHeader); err != nil {
+			return err
+		}
+		for _, c := range counters {
+			record := []string{strconv.Itoa(int(c.DataCenter)), c.DB, c.Table, strconv.FormatInt(c.Value, 10)}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return ErrSnapshotFormat
+	}
+}
+
+// ReadSnapshot 读取WriteSnapshot写入的快照
+func ReadSnapshot(r io.Reader, format string) ([]Counter, error) {
+	switch format {
+	case SnapshotJSON:
+		var counters []Counter
+		if err := json.NewDecoder(r).Decode(&counters); err != nil {
+			return nil, err
+		}
+		return counters, nil
+	case SnapshotCSV:
+		records, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, ErrSnapshotFormat
+		}
+		counters := make([]Counter, 0, len(records)-1)
+		for _, record := range records[1:] {
+			if len(record) != len(snapshotHeader) {
+				return nil, ErrSnapshotFormat
+			}
+			dc, err := strconv.ParseUint(record[0], 10, 8)
+			if err != nil {
+				return nil, err
+			}
+			value, err := strconv.ParseInt(record[3], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			counters = append(counters, Counter{DataCenter: uint8(dc), DB: record[1], Table: record[2], Value: value})
+		}
+		return counters, nil
+	default:
+		return nil, ErrSnapshotFormat
+	}
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot_ExportImport(t *testing.T) {
+	ctx := context.TODO()
+	from := NewMemory()
+	for _, table := range []string{"b", "a"} {
+		_, err := from.Range(ctx, testDC, testDB, table, testSize)
+		assert.NoError(t, err)
+	}
+	_, err := from.Range(ctx, testDC, testDB, "a", testSize)
+	assert.NoError(t, err)
+
+	counters, err := Export(ctx, from, testDC)
+	assert.NoError(t, err)
+	assert.Equal(t, counters, []Counter{
+		{DataCenter: testDC, DB: testDB, Table: "a", Value: testSize * 2},
+		{DataCenter: testDC, DB: testDB, Table: "b", Value: testSize},
+	})
+
+	// 只增不减：已经更大的计数跳过
+	to := NewMemory()
+	_, err = to.Range(ctx, testDC, testDB, "b", testSize*10)
+	assert.NoError(t, err)
+	result, err := Import(ctx, to, counters, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, result, ImportResult{Raised: 1, Skipped: 1})
+	n, err := to.GetCounter(ctx, testDC, testDB, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, n, testSize*2+1)
+	n, err = to.GetCounter(ctx, testDC, testDB, "b")
+	assert.NoError(t, err)
+	assert.Equal(t, n, testSize*10)
+
+	diffs, err := Diff(ctx, from, to, testDC)
+	assert.NoError(t, err)
+	assert.Equal(t, diffs, []CounterDiff{
+		{DB: testDB, Table: "a", From: testSize * 2, To: testSize*2 + 1},
+		{DB: testDB, Table: "b", From: testSize, To: testSize * 10},
+	})
+
+	// 只存在于一边的table
+	_, err = to.Range(ctx, testDC, "other", "c", testSize)
+	assert.NoError(t, err)
+	diffs, err = Diff(ctx, from, to, testDC)
+	assert.NoError(t, err)
+	assert.Equal(t, diffs[0], CounterDiff{DB: "other", Table: "c", To: testSize})
+}
+
+func TestSnapshot_Format(t *testing.T) {
+	counters := []Counter{
+		{DataCenter: 1, DB: testDB, Table: "a,b", Value: testSize},
+		{DataCenter: 1, DB: testDB, Table: "c", Value: 1},
+	}
+	for _, format := range []string{SnapshotJSON, SnapshotCSV} {
+		var buf bytes.Buffer
+		assert.NoError(t, WriteSnapshot(&buf, format, counters))
+		got, err := ReadSnapshot(&buf, format)
+		assert.NoError(t, err)
+		assert.Equal(t, got, counters, format)
+	}
+	assert.Equal(t, WriteSnapshot(new(bytes.Buffer), "xml", counters), ErrSnapshotFormat)
+	_, err := ReadSnapshot(bytes.NewBufferString("dc,db,table\n1,a,b\n"), SnapshotCSV)
+	assert.Equal(t, err, ErrSnapshotFormat)
+}
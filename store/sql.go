@@ -0,0 +1,319 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// 支持的数据库驱动
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// sqlSchemaDB 已经创建的db，BlockDB 和 Range 根据这里判断db是否存在
+	sqlSchemaDB = `CREATE TABLE IF NOT EXISTS rabbitid_db (
+	dc INT NOT NULL,
+	db VARCHAR(128) NOT NULL,
+	PRIMARY KEY (dc, db)
+)`
+	// sqlSchemaTicket 号段表，max_id 表示已经分配的最大ID
+	sqlSchemaTicket = `CREATE TABLE IF NOT EXISTS rabbitid (
+	dc INT NOT NULL,
+	db VARCHAR(128) NOT NULL,
+	tbl VARCHAR(128) NOT NULL,
+	max_id BIGINT NOT NULL,
+	PRIMARY KEY (dc, db, tbl)
+)`
+	sqlSelectDB     = "SELECT 1 FROM rabbitid_db WHERE dc = ? AND db = ?"
+	sqlUpdateTicket = "UPDATE rabbitid SET max_id = max_id + ? WHERE dc = ? AND db = ? AND tbl = ?"
+	sqlInsertTicket = "INSERT INTO rabbitid (dc, db, tbl, max_id) VALUES (?, ?, ?, ?)"
+	sqlSelectTicket = "SELECT max_id FROM rabbitid WHERE dc = ? AND db = ? AND tbl = ?"
+	sqlInsertDB     = "INSERT INTO rabbitid_db (dc, db) VALUES (?, ?)"
+	sqlListDB       = "SELECT db FROM rabbitid_db WHERE dc = ? ORDER BY db"
+	sqlListTicket   = "SELECT tbl FROM rabbitid WHERE dc = ? AND db = ? ORDER BY tbl"
+	sqlDeleteTicket = "DELETE FROM rabbitid WHERE dc = ? AND db = ? AND tbl = ?"
+
+	// sqlBlockTimeout BlockDB 查询超时时间
+	sqlBlockTimeout = 100 * time.Millisecond
+	// sqlDBCacheTTL BlockDB 缓存db是否存在的时间，避免后台任务每次都查询数据库
+	sqlDBCacheTTL = 10 * time.Second
+	// sqlRetryTimes 并发插入新的table时主键冲突的重试次数
+	sqlRetryTimes = 10
+)
+
+// sqlDrivers 存储类型对应的database/sql驱动
+var sqlDrivers = map[string]string{
+	"mysql":    "mysql",
+	"postgres": "postgres",
+	"sqlite":   "sqlite3",
+}
+
+// ErrSQLFail 数据库更新出错
+var ErrSQLFail = errors.New("sql save error")
+
+// A SQL 使用关系型数据库的号段表存储发号进度，参考flickr的ticket server。
+// 每个(dc, db, table)一行，在事务中执行 max_id = max_id + size
+type SQL struct {
+	db      *sql.DB
+	dialect string
+	dbs     *sqlDBCache
+	log     *logrus.Entry
+}
+
+// sqlDBCache 缓存db是否存在，超过ttl重新查询
+type sqlDBCache struct {
+	ttl    time.Duration
+	mu     sync.Mutex
+	exists map[string]sqlDBState
+}
+
+type sqlDBState struct {
+	exists bool
+	at     time.Time
+}
+
+func newSQLDBCache(ttl time.Duration) *sqlDBCache {
+	return &sqlDBCache{ttl: ttl, exists: make(map[string]sqlDBState)}
+}
+
+func (c *sqlDBCache) get(dataCenter uint8, db string) (exists, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, ok := c.exists[fmt.Sprintf("%d/%s", dataCenter, db)]
+	if !ok || time.Since(state.at) > c.ttl {
+		return false, false
+	}
+	return state.exists, true
+}
+
+func (c *sqlDBCache) set(dataCenter uint8, db string, exists bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.exists[fmt.Sprintf("%d/%s", dataCenter, db)] = sqlDBState{exists: exists, at: time.Now()}
+}
+
+// NewSQL 获取数据库实例，dialect 支持mysql、postgres、sqlite，dsn 为对应驱动的连接串
+func NewSQL(dialect, dsn string, logger *logrus.Entry) SQL {
+	p, err := openSQL(dialect, dsn, logger)
+	if err != nil {
+		log.Fatalln("sql init error", dialect, err.Error())
+	}
+	return p
+}
+
+// openSQL 和NewSQL相同，出错时返回错误
+func openSQL(dialect, dsn string, logger *logrus.Entry) (SQL, error) {
+	driver, ok := sqlDrivers[dialect]
+	if !ok {
+		return SQL{}, fmt.Errorf("sql dialect error %s", dialect)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return SQL{}, err
+	}
+	// sqlite 只支持单个写入者，避免database is locked
+	if dialect == "sqlite" {
+		db.SetMaxOpenConns(1)
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return SQL{}, err
+	}
+	return SQL{db: db, dialect: dialect, dbs: newSQLDBCache(sqlDBCacheTTL), log: logger.WithField("store", dialect)}, nil
+}
+
+// rebind postgres 使用$1作为占位符
+func (p SQL) rebind(query string) string {
+	if p.dialect != "postgres" {
+		return query
+	}
+	parts := strings.Split(query, "?")
+	var b strings.Builder
+	for i, part := range parts {
+		b.WriteString(part)
+		if i < len(parts)-1 {
+			b.WriteString("$" + strconv.Itoa(i+1))
+		}
+	}
+	return b.String()
+}
+
+// Range 分片分配进度, 返回v 表示可用范围(v, v+size]
+func (p SQL) Range(ctx context.Context, dataCenter uint8, db, table string, size int64) (int64, error) {
+	l := p.log.WithFields(logrus.Fields{"action": "range", "db": db, "table": table, "size": size})
+	// 并发插入新的table时主键冲突，重试走更新逻辑
+	for i := 0; i < sqlRetryTimes; i++ {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		max, err := p.incr(ctx, dataCenter, db, table, size)
+		switch err {
+		case nil:
+			return max - size, nil
+		case ErrDBNotExists:
+			p.dbs.set(dataCenter, db, false)
+			return 0, err
+		case context.Canceled, context.DeadlineExceeded:
+			return 0, err
+		default:
+			l.WithError(err).Error("sql update fail, try again")
+		}
+	}
+	return 0, ErrSQLFail
+}
+
+// incr 在事务中增加max_id，不存在则插入，返回增加后的max_id
+func (p SQL) incr(ctx context.Context, dataCenter uint8, db, table string, size int64) (max int64, err error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+	var one int
+	err = tx.QueryRowContext(ctx, p.rebind(sqlSelectDB), dataCenter, db).Scan(&one)
+	if err == sql.ErrNoRows {
+		return 0, ErrDBNotExists
+	}
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, p.rebind(sqlUpdateTicket), size, dataCenter, db, table)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		if _, err = tx.ExecContext(ctx, p.rebind(sqlInsertTicket), dataCenter, db, table, size); err != nil {
+			return 0, err
+		}
+		return size, nil
+	}
+	err = tx.QueryRowContext(ctx, p.rebind(sqlSelectTicket), dataCenter, db, table).Scan(&max)
+	return max, err
+}
+
+// Ping 测试连接状态
+func (p SQL) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+// Close 关闭数据库连接
+func (p SQL) Close() error {
+	return p.db.Close()
+}
+
+// Init 创建号段表
+func (p SQL) Init(dataCenter uint8) error {
+	for _, schema := range []string{sqlSchemaDB, sqlSchemaTicket} {
+		if _, err := p.db.Exec(schema); err != nil {
+			p.log.WithError(err).Error("create schema fail")
+			return err
+		}
+	}
+	return nil
+}
+
+// BlockDB db不存在时跳过加载，结果缓存sqlDBCacheTTL，查询出错时不跳过也不缓存
+func (p SQL) BlockDB(dataCenter uint8, db string) bool {
+	if exists, ok := p.dbs.get(dataCenter, db); ok {
+		return !exists
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sqlBlockTimeout)
+	defer cancel()
+	var one int
+	err := p.db.QueryRowContext(ctx, p.rebind(sqlSelectDB), dataCenter, db).Scan(&one)
+	switch err {
+	case nil:
+		p.dbs.set(dataCenter, db, true)
+	case sql.ErrNoRows:
+		p.dbs.set(dataCenter, db, false)
+		return true
+	}
+	return false
+}
+
+// CreateDB 在rabbitid_db中插入db，已经存在不报错
+func (p SQL) CreateDB(ctx context.Context, dataCenter uint8, db string) error {
+	if err := p.createDB(ctx, dataCenter, db); err != nil {
+		return err
+	}
+	p.dbs.set(dataCenter, db, true)
+	return nil
+}
+
+func (p SQL) createDB(ctx context.Context, dataCenter uint8, db string) error {
+	var one int
+	err := p.db.QueryRowContext(ctx, p.rebind(sqlSelectDB), dataCenter, db).Scan(&one)
+	if err != sql.ErrNoRows {
+		return err
+	}
+	if _, err = p.db.ExecContext(ctx, p.rebind(sqlInsertDB), dataCenter, db); err != nil {
+		// 并发创建时主键冲突，再次检查
+		if p.db.QueryRowContext(ctx, p.rebind(sqlSelectDB), dataCenter, db).Scan(&one) == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// ListDBs rabbitid_db中机房下的所有db
+func (p SQL) ListDBs(ctx context.Context, dataCenter uint8) ([]string, error) {
+	return p.list(ctx, sqlListDB, dataCenter)
+}
+
+// ListTables 号段表中db下的所有table
+func (p SQL) ListTables(ctx context.Context, dataCenter uint8, db string) ([]string, error) {
+	return p.list(ctx, sqlListTicket, dataCenter, db)
+}
+
+// GetCounter 已经分配的最大值
+func (p SQL) GetCounter(ctx context.Context, dataCenter uint8, db, table string) (int64, error) {
+	var max int64
+	err := p.db.QueryRowContext(ctx, p.rebind(sqlSelectTicket), dataCenter, db, table).Scan(&max)
+	if err == sql.ErrNoRows {
+		return 0, ErrTableNotExists
+	}
+	return max, err
+}
+
+// DeleteTable 删除号段表中的记录
+func (p SQL) DeleteTable(ctx context.Context, dataCenter uint8, db, table string) error {
+	_, err := p.db.ExecContext(ctx, p.rebind(sqlDeleteTicket), dataCenter, db, table)
+	return err
+}
+
+func (p SQL) list(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := p.db.QueryContext(ctx, p.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestSQLite 使用临时文件创建sqlite存储，并创建testDB
+func newTestSQLite(t *testing.T) (SQL, func()) {
+	dir, err := ioutil.TempDir("", "rabbitid")
+	assert.NoError(t, err)
+	client := NewSQL("sqlite", filepath.Join(dir, "rabbitid.db")+"?_busy_timeout=5000", logrus.NewEntry(logrus.New()))
+	assert.NoError(t, client.Init(testDC))
+	_, err = client.db.Exec("INSERT INTO rabbitid_db (dc, db) VALUES (?, ?)", testDC, testDB)
+	assert.NoError(t, err)
+	return client, func() {
+		client.db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSQL_Range(t *testing.T) {
+	client, cleanup := newTestSQLite(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	n, err := client.Range(ctx, testDC, testDB, testTable, testSize)
+	cancel()
+	assert.NoError(t, err)
+	assert.Equal(t, n, int64(0))
+
+	ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	n, err = client.Range(ctx, testDC, testDB, testTable, testSize)
+	cancel()
+	assert.NoError(t, err)
+	assert.Equal(t, n, testSize)
+
+	// db 不存在
+	_, err = client.Range(context.TODO(), testDC, "not_exists", testTable, testSize)
+	assert.EqualError(t, err, ErrDBNotExists.Error())
+}
+
+func TestSQL_BlockDB(t *testing.T) {
+	client, cleanup := newTestSQLite(t)
+	defer cleanup()
+
+	assert.False(t, client.BlockDB(testDC, testDB))
+	assert.True(t, client.BlockDB(testDC, "not_exists"))
+
+	// 缓存时间内不再查询数据库，CreateDB 立即生效
+	_, err := client.db.Exec("INSERT INTO rabbitid_db (dc, db) VALUES (?, ?)", testDC, "not_exists")
+	assert.NoError(t, err)
+	assert.True(t, client.BlockDB(testDC, "not_exists"))
+	assert.True(t, client.BlockDB(testDC, "created"))
+	assert.NoError(t, client.CreateDB(context.TODO(), testDC, "created"))
+	assert.False(t, client.BlockDB(testDC, "created"))
+
+	// 缓存过期后重新查询
+	client.dbs.ttl = 0
+	assert.False(t, client.BlockDB(testDC, "not_exists"))
+}
+
+func TestSQL_Rebind(t *testing.T) {
+	client := SQL{dialect: "postgres"}
+	assert.Equal(t, client.rebind(sqlUpdateTicket),
+		"UPDATE rabbitid SET max_id = max_id + $1 WHERE dc = $2 AND db = $3 AND tbl = $4")
+}
+
+func TestSQL_Ping(t *testing.T) {
+	client, cleanup := newTestSQLite(t)
+	defer cleanup()
+	assert.NoError(t, client.Ping(context.TODO()))
+}
@@ -3,7 +3,7 @@ package store
 
 import (
 	"context"
-	"log"
+	"io"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -11,7 +11,11 @@ import (
 
 // A Store 存储接口
 type Store interface {
-	// Range 获取数据，传入数据中心ID，业务唯一ID和获取连续的区间大小，得到连续的最大ID和错误[id-size, id)
+	// Range 获取数据，传入数据中心ID，业务唯一ID和获取连续的区间大小，得到区间的起始值和错误，
+	// 可用范围为(id, id+size]，和generator.Segment.Expand一致。
+	// 同一个(dataCenter, db, table)多次调用得到的区间不会重叠，第一次调用返回0；
+	// 需要提前创建db的存储，在db不存在时返回ErrDBNotExists；ctx 取消时返回ctx.Err()。
+	// 所有实现都需要通过storetest.RunSuite
 	Range(ctx context.Context, dataCenter uint8, db, table string, size int64) (id int64, err error)
 	// Init 这里可以完成初始化方法，保证服务的可用
 	Init(dataCenter uint8) error
@@ -23,20 +27,111 @@ type Store interface {
 
 var (
 	ErrDBNotExists = errors.New("zk: db does not exist")
+	// ErrUnknownStore 存储类型没有注册
+	ErrUnknownStore = errors.New("unknown store type")
 )
 
-func NewStore(storeType, uri string, dataCenter uint8, logger *logrus.Entry) Store {
-	var db Store
-	switch storeType {
-	default:
-		log.Fatalln("store type Error", storeType)
-	case "redis":
-		db = NewRedis(uri, logger.WithField("store", storeType))
-	case "etcd":
-		db = NewEtcd(uri, logger.WithField("store", storeType))
-	case "zk":
-		db = NewZK(uri, logger.WithField("store", storeType))
-	}
-	db.Init(dataCenter)
-	return db
+// A StoreOption NewStore的可选配置，用于传入各个存储自己的配置
+type StoreOption func(*storeOptions)
+
+type storeOptions struct {
+	zk         []Option
+	etcd       []EtcdOption
+	middleware []Middleware
+	// skipInit 只读取存储时不初始化，例如导出和比较计数
+	skipInit bool
+}
+
+// WithZK zk存储的配置，例如Credentials、ACL、SessionTimeout
+func WithZK(opts ...Option) StoreOption {
+	return func(o *storeOptions) {
+		o.zk = append(o.zk, opts...)
+	}
+}
+
+// WithEtcd etcd存储的配置，例如EtcdTLS、EtcdCredentials、EtcdRoot
+func WithEtcd(opts ...EtcdOption) StoreOption {
+	return func(o *storeOptions) {
+		o.etcd = append(o.etcd, opts...)
+	}
+}
+
+// WithMiddleware 使用中间件包装存储，例如CircuitBreaker、Retry、Timeout，第一个在最外层
+func WithMiddleware(mws ...Middleware) StoreOption {
+	return func(o *storeOptions) {
+		o.middleware = append(o.middleware, mws...)
+	}
+}
+
+// newStoreOptions 合并NewStore的可选配置
+// WithoutInit NewStore 不调用Init，Init 会写入存储，例如zk的升级和sql建表，只读取计数时使用
+func WithoutInit() StoreOption {
+	return func(o *storeOptions) {
+		o.skipInit = true
+	}
+}
+
+func newStoreOptions(opts []StoreOption) storeOptions {
+	var o storeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// NewStore 按照存储类型生成存储，完成初始化并使用中间件包装，WithoutInit 时跳过初始化。
+// storeType 为空时使用uri的scheme，例如 redis://127.0.0.1:6379、etcd://127.0.0.1:2379、zk://127.0.0.1:2181、
+// file:///tmp/rabbitid.bolt、mysql://root@tcp(127.0.0.1:3306)/rabbitid；
+// 存储类型需要通过Register注册，不存在时返回ErrUnknownStore
+func NewStore(storeType, uri string, dataCenter uint8, logger *logrus.Entry, opts ...StoreOption) (Store, error) {
+	db, err := openStore(storeType, uri, logger, opts...)
+	if err != nil {
+		return nil, err
+	}
+	o := newStoreOptions(opts)
+	if !o.skipInit {
+		if err = db.Init(dataCenter); err != nil {
+			return nil, errors.Wrapf(err, "store init %s", storeType)
+		}
+	}
+	// replicated 的副本已经分别使用中间件包装
+	if _, ok := db.(*Replicated); ok {
+		return db, nil
+	}
+	return Chain(db, o.middleware...), nil
+}
+
+// openStore 使用注册的Factory生成存储，不初始化
+func openStore(storeType, uri string, logger *logrus.Entry, opts ...StoreOption) (Store, error) {
+	if storeType == "" {
+		storeType = scheme(uri)
+	}
+	factory, ok := lookup(storeType)
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownStore, "%q", storeType)
+	}
+	db, err := factory(uri, logger, opts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "store open %s", storeType)
+	}
+	return db, nil
+}
+
+// Close 关闭存储的连接，包装的存储关闭被包装的存储，不需要关闭的存储返回nil
+func Close(s Store) error {
+	for s != nil {
+		switch c := s.(type) {
+		case io.Closer:
+			return c.Close()
+		case interface{ Stop() }:
+			c.Stop()
+			return nil
+		}
+		w, ok := s.(Wrapper)
+		if !ok {
+			break
+		}
+		s = w.Unwrap()
+	}
+	return nil
 }
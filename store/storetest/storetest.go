@@ -0,0 +1,211 @@
+// Package storetest 存储一致性测试，所有store.Store的实现都需要通过RunSuite，
+// 保证Range返回的区间满足store.Store中描述的约定
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luw2007/rabbitid/store"
+)
+
+const (
+	// DB 测试使用的db，需要提前创建db的存储，Factory里需要保证DB存在
+	DB = "storetest"
+	// DataCenter 测试使用的机房
+	DataCenter uint8 = 0
+
+	testSize    int64 = 10
+	testTimeout       = 5 * time.Second
+	// concurrency, rounds 并发测试的goroutine数量和每个goroutine调用次数
+	concurrency = 8
+	rounds      = 20
+)
+
+// Factory 生成待测试的存储，每个子测试调用一次
+type Factory func(t *testing.T) store.Store
+
+// RunSuite 运行一致性测试
+func RunSuite(t *testing.T, factory Factory) {
+	t.Run("Sequential", func(t *testing.T) { testSequential(t, factory(t)) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, factory(t)) })
+	t.Run("MissingKey", func(t *testing.T) { testMissingKey(t, factory(t)) })
+	t.Run("MissingDB", func(t *testing.T) { testMissingDB(t, factory(t)) })
+	t.Run("Canceled", func(t *testing.T) { testCanceled(t, factory(t)) })
+	t.Run("Admin", func(t *testing.T) { testAdmin(t, factory(t)) })
+}
+
+// newTable 每次测试使用新的table，不受之前数据的影响
+func newTable(name string) string {
+	return fmt.Sprintf("%s_%d", name, time.Now().UnixNano())
+}
+
+func rangeOnce(t *testing.T, s store.Store, db, table string, size int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	return s.Range(ctx, DataCenter, db, table, size)
+}
+
+func testSequential(t *testing.T, s store.Store) {
+	table := newTable("sequential")
+	sizes := []int64{testSize, testSize, testSize * 2, 1, testSize}
+	var want int64
+	for i, size := range sizes {
+		got, err := rangeOnce(t, s, DB, table, size)
+		if err != nil {
+			t.Fatalf("range %d: %v", i, err)
+		}
+		if got != want {
+			t.Fatalf("range %d: got start %d, want %d", i, got, want)
+		}
+		want += size
+	}
+}
+
+func testConcurrent(t *testing.T, s store.Store) {
+	table := newTable("concurrent")
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		starts []int64
+		errs   []error
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < rounds; j++ {
+				got, err := rangeOnce(t, s, DB, table, testSize)
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					starts = append(starts, got)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		t.Fatalf("%d concurrent ranges failed, first: %v", len(errs), errs[0])
+	}
+	// 区间不重叠且连续
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	for i, got := range starts {
+		if want := int64(i) * testSize; got != want {
+			t.Fatalf("range %d: got start %d, want %d", i, got, want)
+		}
+	}
+}
+
+func testMissingKey(t *testing.T, s store.Store) {
+	got, err := rangeOnce(t, s, DB, newTable("missing"), testSize)
+	if err != nil {
+		t.Fatalf("range missing key: %v", err)
+	}
+	if got != 0 {
+		t.Fatalf("range missing key: got start %d, want 0", got)
+	}
+}
+
+func testMissingDB(t *testing.T, s store.Store) {
+	db := newTable("missing_db")
+	got, err := rangeOnce(t, s, db, newTable("missing"), testSize)
+	switch {
+	case err == store.ErrDBNotExists:
+	case err != nil:
+		t.Fatalf("range missing db: got %v, want nil or %v", err, store.ErrDBNotExists)
+	case got != 0:
+		t.Fatalf("range missing db: got start %d, want 0", got)
+	}
+}
+
+func testCanceled(t *testing.T, s store.Store) {
+	table := newTable("canceled")
+	first, err := rangeOnce(t, s, DB, table, testSize)
+	if err != nil {
+		t.Fatalf("range: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.Range(ctx, DataCenter, DB, table, testSize); err != context.Canceled {
+		t.Fatalf("range with canceled context: got %v, want %v", err, context.Canceled)
+	}
+	// 取消的请求可以浪费号段，但是不能重复
+	got, err := rangeOnce(t, s, DB, table, testSize)
+	if err != nil {
+		t.Fatalf("range after cancel: %v", err)
+	}
+	if got < first+testSize {
+		t.Fatalf("range after cancel: got start %d, want >= %d", got, first+testSize)
+	}
+}
+
+// testAdmin 支持store.Admin的存储需要能创建db，列出和删除计数，不支持的跳过
+func testAdmin(t *testing.T, s store.Store) {
+	admin, err := store.AdminOf(s)
+	if err == store.ErrAdminNotSupported {
+		t.Skip(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	db := newTable("admin_db")
+	// 重复创建不报错
+	for i := 0; i < 2; i++ {
+		if err := admin.CreateDB(ctx, DataCenter, db); err != nil {
+			t.Fatalf("create db: %v", err)
+		}
+	}
+	dbs, err := admin.ListDBs(ctx, DataCenter)
+	if err != nil {
+		t.Fatalf("list dbs: %v", err)
+	}
+	if !contains(dbs, db) {
+		t.Fatalf("list dbs: %v does not contain %s", dbs, db)
+	}
+
+	tables := []string{"a_table", "b_table"}
+	for _, table := range tables {
+		if _, err := rangeOnce(t, s, db, table, testSize); err != nil {
+			t.Fatalf("range in created db: %v", err)
+		}
+	}
+	got, err := admin.ListTables(ctx, DataCenter, db)
+	if err != nil {
+		t.Fatalf("list tables: %v", err)
+	}
+	if !reflect.DeepEqual(got, tables) {
+		t.Fatalf("list tables: got %v, want %v", got, tables)
+	}
+	if max, err := admin.GetCounter(ctx, DataCenter, db, tables[0]); err != nil || max != testSize {
+		t.Fatalf("get counter: got %d, %v, want %d", max, err, testSize)
+	}
+	if _, err := admin.GetCounter(ctx, DataCenter, db, "missing"); err != store.ErrTableNotExists {
+		t.Fatalf("get missing counter: got %v, want %v", err, store.ErrTableNotExists)
+	}
+
+	if err := admin.DeleteTable(ctx, DataCenter, db, tables[0]); err != nil {
+		t.Fatalf("delete table: %v", err)
+	}
+	if got, err = admin.ListTables(ctx, DataCenter, db); err != nil || !reflect.DeepEqual(got, tables[1:]) {
+		t.Fatalf("list tables after delete: got %v, %v, want %v", got, err, tables[1:])
+	}
+	if _, err := admin.GetCounter(ctx, DataCenter, db, tables[0]); err != store.ErrTableNotExists {
+		t.Fatalf("get deleted counter: got %v, want %v", err, store.ErrTableNotExists)
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
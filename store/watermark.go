@@ -0,0 +1,285 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const watermarkTPL = "%d/%s/%s"
+
+// ErrCounterRegression 存储返回的区间低于本地记录的最大值，拉高计数后仍然没有恢复
+var ErrCounterRegression = errors.New("store counter regression")
+
+// A Bumper 可以把计数直接拉高到指定值的存储，只增不减。
+// 没有实现Bumper的存储通过Range跳过差值
+type Bumper interface {
+	// Bump 计数小于min时设置为min，之后Range返回的区间都大于min
+	Bump(ctx context.Context, dataCenter uint8, db, table string, min int64) error
+}
+
+// Raise 把存储的计数拉高到至少min，只增不减，用于计数回退和切换存储。
+// 实现了Bumper的存储直接Bump，否则先Range(1)获取当前计数，再Range跳过差值
+func Raise(ctx context.Context, s Store, dataCenter uint8, db, table string, min int64) error {
+	if b, ok := BumperOf(s); ok {
+		return b.Bump(ctx, dataCenter, db, table, min)
+	}
+	cur, err := s.Range(ctx, dataCenter, db, table, 1)
+	if err != nil {
+		return err
+	}
+	if cur+1 >= min {
+		return nil
+	}
+	_, err = s.Range(ctx, dataCenter, db, table, min-cur-1)
+	return err
+}
+
+// A Watermark 在本地文件中记录每个(dataCenter, db, table)已经分配的最大值。
+// 存储丢失数据（redis没有开启AOF时主从切换、FLUSHALL、从旧的RDB恢复）后计数会回退，
+// 返回的区间低于本地记录时拒绝发号，并把存储的计数拉高到本地记录之后，防止发出重复的ID。
+// 文件中的记录比已经分配的最大值多预留watermarkAhead个号段，预留范围内的Range不写文件，
+// 重启后从预留的位置继续发号，跳过的号码不会重复
+type Watermark struct {
+	Store
+	*watermarkFile
+	log *logrus.Entry
+}
+
+// watermarkAhead 落盘时预留的号段数量
+const watermarkAhead = 15
+
+// watermarkFile 本地记录，切换存储时新旧Watermark共用
+type watermarkFile struct {
+	path string
+	mu   sync.Mutex
+	// max 已经分配的最大值，启动时为文件中的记录
+	max map[string]int64
+	// want 需要落盘的记录，saved 已经落盘的记录
+	want  map[string]int64
+	saved map[string]int64
+	// saveMu 串行写文件，等待中的请求合并成一次写入
+	saveMu sync.Mutex
+}
+
+// NewWatermark 包装存储，path 为本地记录文件，不存在则创建
+func NewWatermark(s Store, path string, logger *logrus.Entry) (*Watermark, error) {
+	saved := make(map[string]int64)
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err = json.Unmarshal(data, &saved); err != nil {
+			return nil, fmt.Errorf("watermark file %s: %v", path, err)
+		}
+	}
+	f := &watermarkFile{path: path, max: copyCounters(saved), want: copyCounters(saved), saved: saved}
+	return &Watermark{Store: s, watermarkFile: f, log: logger.WithField("store", "watermark")}, nil
+}
+
+// With 使用同一个本地记录包装新的存储，用于切换存储
+func (p *Watermark) With(s Store) *Watermark {
+	return &Watermark{Store: s, watermarkFile: p.watermarkFile, log: p.log}
+}
+
+// Range 分片分配进度, 返回v 表示可用范围(v, v+size]。
+// 返回的区间低于本地记录时，拉高存储计数后重新获取一次
+func (p *Watermark) Range(ctx context.Context, dataCenter uint8, db, table string, size int64) (int64, error) {
+	biz := fmt.Sprintf(watermarkTPL, dataCenter, db, table)
+	// 在Range之前读取，并发Range时其他请求写入的更大值不会被误判为回退
+	max := p.get(biz)
+	min, err := p.Store.Range(ctx, dataCenter, db, table, size)
+	if err != nil {
+		return 0, err
+	}
+	if min < max {
+		// 重启后的第一次Range低于预留的记录是正常的，也需要拉高
+		l := p.log.WithFields(logrus.Fields{"action": "range", "biz": biz, "min": min, "watermark": max})
+		l.Warn("store counter below watermark, bump store")
+		if err = Raise(ctx, p.Store, dataCenter, db, table, max); err != nil {
+			l.WithError(err).Error("bump fail")
+			return 0, err
+		}
+		if min, err = p.Store.Range(ctx, dataCenter, db, table, size); err != nil {
+			return 0, err
+		}
+		if min < max {
+			l.WithField("min", min).Error("counter still regressed")
+			return 0, ErrCounterRegression
+		}
+	}
+	// 先落盘再发号，进程重启后也不会重复
+	if err = p.set(biz, min+size, size*watermarkAhead); err != nil {
+		p.log.WithFields(logrus.Fields{"action": "save", "biz": biz}).WithError(err).Error()
+		return 0, err
+	}
+	return min, nil
+}
+
+// Bump 拉高存储的计数并更新本地记录，Import 和切换存储通过Raise调用
+func (p *Watermark) Bump(ctx context.Context, dataCenter uint8, db, table string, min int64) error {
+	if err := Raise(ctx, p.Store, dataCenter, db, table, min); err != nil {
+		return err
+	}
+	return p.set(fmt.Sprintf(watermarkTPL, dataCenter, db, table), min, 0)
+}
+
+// CreateDB 使用被包装的存储的管理接口
+func (p *Watermark) CreateDB(ctx context.Context, dataCenter uint8, db string) error {
+	admin, err := AdminOf(p.Store)
+	if err != nil {
+		return err
+	}
+	return admin.CreateDB(ctx, dataCenter, db)
+}
+
+// ListDBs 使用被包装的存储的管理接口
+func (p *Watermark) ListDBs(ctx context.Context, dataCenter uint8) ([]string, error) {
+	admin, err := AdminOf(p.Store)
+	if err != nil {
+		return nil, err
+	}
+	return admin.ListDBs(ctx, dataCenter)
+}
+
+// ListTables 使用被包装的存储的管理接口
+func (p *Watermark) ListTables(ctx context.Context, dataCenter uint8, db string) ([]string, error) {
+	admin, err := AdminOf(p.Store)
+	if err != nil {
+		return nil, err
+	}
+	return admin.ListTables(ctx, dataCenter, db)
+}
+
+// GetCounter 使用被包装的存储的管理接口
+func (p *Watermark) GetCounter(ctx context.Context, dataCenter uint8, db, table string) (int64, error) {
+	admin, err := AdminOf(p.Store)
+	if err != nil {
+		return 0, err
+	}
+	return admin.GetCounter(ctx, dataCenter, db, table)
+}
+
+// DeleteTable 删除存储中的计数和本地记录，之后Range从0开始
+func (p *Watermark) DeleteTable(ctx context.Context, dataCenter uint8, db, table string) error {
+	admin, err := AdminOf(p.Store)
+	if err != nil {
+		return err
+	}
+	if err = admin.DeleteTable(ctx, dataCenter, db, table); err != nil {
+		return err
+	}
+	return p.delete(fmt.Sprintf(watermarkTPL, dataCenter, db, table))
+}
+
+// Unwrap 被包装的存储
+func (p *Watermark) Unwrap() Store { return p.Store }
+
+// Watermark 本地记录的最大值，没有记录返回0
+func (p *Watermark) Watermark(dataCenter uint8, db, table string) int64 {
+	return p.get(fmt.Sprintf(watermarkTPL, dataCenter, db, table))
+}
+
+func (p *watermarkFile) get(biz string) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.max[biz]
+}
+
+// set 只增不减，max 没有超过已经落盘的记录时不写文件，否则多预留ahead后落盘
+func (p *watermarkFile) set(biz string, max, ahead int64) error {
+	p.mu.Lock()
+	if p.max[biz] < max {
+		p.max[biz] = max
+	}
+	if max <= p.saved[biz] {
+		p.mu.Unlock()
+		return nil
+	}
+	if p.want[biz] < max+ahead {
+		p.want[biz] = max + ahead
+	}
+	p.mu.Unlock()
+	return p.flush(func() bool { return max <= p.saved[biz] })
+}
+
+// delete 删除记录并落盘
+func (p *watermarkFile) delete(biz string) error {
+	p.mu.Lock()
+	delete(p.max, biz)
+	delete(p.want, biz)
+	p.mu.Unlock()
+	return p.flush(func() bool {
+		_, ok := p.saved[biz]
+		return !ok
+	})
+}
+
+// flush 写入当前所有的记录，done 在持有mu时调用，等待期间其他请求已经写入时直接返回
+func (p *watermarkFile) flush(done func() bool) error {
+	p.saveMu.Lock()
+	defer p.saveMu.Unlock()
+	p.mu.Lock()
+	if done() {
+		p.mu.Unlock()
+		return nil
+	}
+	want := copyCounters(p.want)
+	p.mu.Unlock()
+	if err := p.save(want); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.saved = want
+	p.mu.Unlock()
+	return nil
+}
+
+// save 写入临时文件后rename，避免写一半时进程退出，再同步目录保证rename落盘
+func (p *watermarkFile) save(counters map[string]int64) error {
+	data, err := json.Marshal(counters)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(p.path)
+	f, err := ioutil.TempFile(dir, filepath.Base(p.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err = os.Rename(f.Name(), p.path); err != nil {
+		return err
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func copyCounters(m map[string]int64) map[string]int64 {
+	c := make(map[string]int64, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
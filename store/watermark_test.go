@@ -0,0 +1,136 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatermark_Range(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rabbitid")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "watermark.json")
+	log := logrus.NewEntry(logrus.New())
+
+	db := NewMemory()
+	client, err := NewWatermark(db, path, log)
+	assert.NoError(t, err)
+	n, err := client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, int64(0))
+	n, err = client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, testSize)
+	assert.Equal(t, client.Watermark(testDC, testDB, testTable), testSize*2)
+
+	// 存储丢失数据，重启后从文件中恢复预留的记录，拒绝回退的区间并拉高计数
+	db.Reset()
+	client, err = NewWatermark(db, path, log)
+	assert.NoError(t, err)
+	n, err = client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, testSize*(1+watermarkAhead))
+	assert.Equal(t, client.Watermark(testDC, testDB, testTable), testSize*(2+watermarkAhead))
+}
+
+func TestWatermark_Reserve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rabbitid")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "watermark.json")
+
+	client, err := NewWatermark(NewMemory(), path, logrus.NewEntry(logrus.New()))
+	assert.NoError(t, err)
+	_, err = client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	saved, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+
+	// 预留范围内不写文件
+	for i := 0; i < watermarkAhead; i++ {
+		_, err = client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+		assert.NoError(t, err)
+	}
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, string(data), string(saved))
+
+	// 超过预留后写入新的记录
+	_, err = client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	data, err = ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotEqual(t, string(data), string(saved))
+}
+
+func TestWatermark_Admin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rabbitid")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "watermark.json")
+	log := logrus.NewEntry(logrus.New())
+
+	db := NewMemory()
+	client, err := NewWatermark(db, path, log)
+	assert.NoError(t, err)
+	admin, err := AdminOf(client)
+	assert.NoError(t, err)
+	assert.Equal(t, admin, Admin(client))
+
+	// 导入计数同时更新本地记录
+	_, err = Import(context.TODO(), client, []Counter{{DataCenter: testDC, DB: testDB, Table: testTable, Value: testSize * 100}}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, client.Watermark(testDC, testDB, testTable), testSize*100)
+
+	// 删除table同时删除本地记录，之后从0开始
+	assert.NoError(t, admin.DeleteTable(context.TODO(), testDC, testDB, testTable))
+	assert.Equal(t, client.Watermark(testDC, testDB, testTable), int64(0))
+	client, err = NewWatermark(db, path, log)
+	assert.NoError(t, err)
+	n, err := client.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, int64(0))
+}
+
+func TestWatermark_RangeWithoutBump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rabbitid")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "watermark.json")
+	log := logrus.NewEntry(logrus.New())
+
+	client := NewFile(filepath.Join(dir, "a.bolt"), log)
+	wm, err := NewWatermark(client, path, log)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = wm.Range(context.TODO(), testDC, testDB, testTable, testSize)
+		assert.NoError(t, err)
+	}
+	client.Close()
+
+	// 换成空的存储，通过Range跳过差值
+	client = NewFile(filepath.Join(dir, "b.bolt"), log)
+	defer client.Close()
+	wm, err = NewWatermark(client, path, log)
+	assert.NoError(t, err)
+	n, err := wm.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.NoError(t, err)
+	assert.Equal(t, n, testSize*(1+watermarkAhead))
+}
+
+func TestWatermark_BadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rabbitid")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "watermark.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("{"), 0600))
+
+	_, err = NewWatermark(NewMemory(), path, logrus.NewEntry(logrus.New()))
+	assert.Error(t, err)
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/samuel/go-zookeeper/zk"
@@ -19,53 +21,74 @@ var (
 	DefaultACL            = zk.WorldACL(zk.PermAll)
 	ErrInvalidCredentials = errors.New("invalid credentials provided")
 	ErrZKFail             = errors.New("zk save error")
+	// ErrZKInactive zk会话已经断开或者过期，等待重连
+	ErrZKInactive = errors.New("zk session is not active")
 )
 
 const (
 	zkTPL  = "%s/%d/%s/%s"
 	zkRoot = "/rabbitid"
+	// zkVersionTPL 机房的Range版本节点，zkRangeStart 表示Range返回区间的起始值
+	zkVersionTPL = "%s/range_version_%d"
+	zkRangeStart = "start"
+	// DefaultUpgradeStep 升级时计数增加的数量，之前的版本每次最多加载step*1024
+	DefaultUpgradeStep = 1000 * 1024
 	// DefaultConnectTimeout is the default timeout to establish a connection to
 	// a ZooKeeper node.
 	DefaultConnectTimeout = 2 * time.Second
 	// DefaultSessionTimeout is the default timeout to keep the current
 	// ZooKeeper session alive during a temporary disconnect.
 	DefaultSessionTimeout = 5 * time.Second
-	// defaultFailSleep 失败休眠时间
-	defaultFailSleep = 1 * time.Second
 )
 
 // A ZK 使用zookeeper做存储
 type ZK struct {
-	conn    *zk.Conn
-	config  zkConfig
-	active  bool
-	quit    chan struct{}
-	blackDB map[string]time.Time
-	log     *logrus.Entry
+	conn   *zk.Conn
+	config zkConfig
+	// active 会话是否可用，由zk的会话事件更新，1表示可用
+	active int32
+	quit   chan struct{}
+	log    *logrus.Entry
 }
 
-// NewZK 获取redis实例
-func NewZK(clientURI string, logger *logrus.Entry, options ...Option) ZK {
+// NewZK 获取zk实例，Credentials 使用digest认证，创建的节点使用ACL指定的权限
+func NewZK(clientURI string, logger *logrus.Entry, options ...Option) *ZK {
+	p, err := dialZK(clientURI, logger, options...)
+	if err != nil {
+		log.Fatalln("zk init error", clientURI, err.Error())
+	}
+	return p
+}
+
+// dialZK 和NewZK相同，出错时返回错误
+func dialZK(clientURI string, logger *logrus.Entry, options ...Option) (*ZK, error) {
 	servers := strings.Split(clientURI, ",")
 	defaultEventHandler := func(event zk.Event) {
-		logrus.WithFields(logrus.Fields{
+		logger.WithFields(logrus.Fields{
 			"eventtype": event.Type.String(),
 			"server":    event.Server,
 			"state":     event.State.String(),
-		}).WithError(event.Err)
+		}).WithError(event.Err).Info("zk event")
 	}
 	config := zkConfig{
-		acl:            DefaultACL,
 		connectTimeout: DefaultConnectTimeout,
 		sessionTimeout: DefaultSessionTimeout,
+		upgradeStep:    DefaultUpgradeStep,
 		eventHandler:   defaultEventHandler,
 		logger:         logger,
 	}
 	for _, option := range options {
 		if err := option(&config); err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
+	switch {
+	case config.acl != nil:
+	case config.credentials != nil:
+		config.acl = zk.DigestACL(zk.PermAll, config.user, config.pass)
+	default:
+		config.acl = DefaultACL
+	}
 	// dialer overrides the default ZooKeeper library Dialer so we can configure
 	// the connectTimeout. The current library has a hardcoded value of 1 second
 	// and there are reports of race conditions, due to slow DNS resolvers and
@@ -73,21 +96,57 @@ func NewZK(clientURI string, logger *logrus.Entry, options ...Option) ZK {
 	dialer := func(network, address string, _ time.Duration) (net.Conn, error) {
 		return net.DialTimeout(network, address, config.connectTimeout)
 	}
-	conn, _, err := zk.Connect(servers, config.sessionTimeout, withLogger(logger), zk.WithDialer(dialer))
+	conn, events, err := zk.Connect(servers, config.sessionTimeout, withLogger(logger), zk.WithDialer(dialer))
 	if err != nil {
-		log.Fatal("zk connect error", clientURI)
+		return nil, err
 	}
-	return ZK{conn: conn, config: config, active: true, quit: make(chan struct{}), blackDB: make(map[string]time.Time), log: logger}
+	if config.credentials != nil {
+		if err = conn.AddAuth("digest", config.credentials); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	p := &ZK{conn: conn, config: config, active: 1, quit: make(chan struct{}), log: logger}
+	go p.watch(events)
+	return p, nil
 }
 
-// Range 分片分配进度, 返回v 表示可用范围[v, v+size)
-func (p ZK) Range(_ context.Context, dataCenter uint8, db, table string, size int64) (int64, error) {
-	if last, ok := p.blackDB[db]; ok {
-		if time.Since(last) < defaultFailSleep {
-			return 0, ErrDBNotExists
+// watch 处理会话事件，断开和过期时停止发号，重新建立会话后恢复
+func (p *ZK) watch(events <-chan zk.Event) {
+	for {
+		select {
+		case <-p.quit:
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			p.handleEvent(event)
 		}
-		p.blackDB[db] = time.Now()
-		fmt.Println("black", last, "sice", time.Since(last))
+	}
+}
+
+func (p *ZK) handleEvent(event zk.Event) {
+	if event.Type == zk.EventSession {
+		switch event.State {
+		case zk.StateHasSession:
+			atomic.StoreInt32(&p.active, 1)
+		case zk.StateDisconnected, zk.StateExpired:
+			atomic.StoreInt32(&p.active, 0)
+		}
+	}
+	p.config.eventHandler(event)
+}
+
+// isActive 会话是否可用
+func (p *ZK) isActive() bool {
+	return atomic.LoadInt32(&p.active) == 1
+}
+
+// Range 分片分配进度, 返回v 表示可用范围(v, v+size]
+func (p *ZK) Range(ctx context.Context, dataCenter uint8, db, table string, size int64) (int64, error) {
+	if !p.isActive() {
+		return 0, ErrZKInactive
 	}
 	biz := fmt.Sprintf(zkTPL, zkRoot, dataCenter, db, table)
 	l := p.log.WithFields(logrus.Fields{
@@ -101,8 +160,12 @@ func (p ZK) Range(_ context.Context, dataCenter uint8, db, table string, size in
 	var data []byte
 	var stat *zk.Stat
 	var err error
-	// 存在多进程竞争的问题，这里乐观认为会成功
-	for i := 0; i < retryTimes; i++ {
+	// 存在多进程竞争的问题，这里乐观认为会成功。
+	// 版本冲突表示其他进程已经分配成功，一直重试直到ctx超时，其他错误交给Retry中间件
+	for i := 0; ; i++ {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
 		if i > 0 {
 			l.WithFields(logrus.Fields{
 				"next":   next,
@@ -116,7 +179,7 @@ func (p ZK) Range(_ context.Context, dataCenter uint8, db, table string, size in
 		default:
 			// err !=nil && err != zk.ErrNoNode
 			l.WithError(err).Error("can't catch")
-			continue
+			return 0, ErrZKFail
 		case nil:
 			if min, err = strconv.ParseInt(string(data), 10, 64); err != nil {
 				l.WithField("data", string(data)).WithError(err).Error("parse error")
@@ -125,6 +188,7 @@ func (p ZK) Range(_ context.Context, dataCenter uint8, db, table string, size in
 			next = strconv.FormatInt(min+size, 10)
 			_, err = p.conn.Set(biz, []byte(next), stat.Version)
 		case zk.ErrNoNode:
+			min = 0
 			next = strconv.FormatInt(size, 10)
 			_, err = p.conn.Create(biz, []byte(next), 0, p.config.acl)
 		}
@@ -135,58 +199,190 @@ func (p ZK) Range(_ context.Context, dataCenter uint8, db, table string, size in
 		case zk.ErrNodeExists, zk.ErrBadVersion:
 			continue
 		case zk.ErrNoNode:
-			if !p.checkDB(dataCenter, db) {
-				p.blackDB[db] = time.Now()
-			}
+			// 父节点不存在，熔断交给CircuitBreaker中间件
 			return 0, ErrDBNotExists
 		case nil:
-			return min + size, nil
+			return min, nil
 		}
 	}
-	return 0, ErrZKFail
 }
 
-// Ping 测试连接状态
-func (p ZK) Ping(_ context.Context) error {
-	if p.active {
-		_, _, err := p.conn.Get(zkRoot)
-		return err
+// Ping 测试连接状态，会话断开或者过期时返回ErrZKInactive
+func (p *ZK) Ping(_ context.Context) error {
+	if !p.isActive() {
+		return ErrZKInactive
 	}
-	return nil
+	_, _, err := p.conn.Get(zkRoot)
+	return err
 }
 
 // Stop implements the ZooKeeper Client interface.
 func (p *ZK) Stop() {
-	p.active = false
+	atomic.StoreInt32(&p.active, 0)
 	close(p.quit)
 	p.conn.Close()
 }
 
-// Init 这里可以完成初始化方法，保证服务的可用
-func (p ZK) Init(dataCenter uint8) error {
-	biz := fmt.Sprintf("%s/%d", zkRoot, dataCenter)
-	_, _, err := p.conn.Get(biz)
+// Init 创建根节点和机房节点，保证服务的可用，并且完成Range返回值的升级
+func (p *ZK) Init(dataCenter uint8) error {
+	if err := p.CreateParentNodes(dataCenter); err != nil {
+		return err
+	}
+	return p.upgradeRange(dataCenter)
+}
+
+// upgradeRange 之前的版本Range返回区间的结束值，计数比已经发出的ID小一个号段，
+// 直接升级会重复发出最后一个号段。没有版本节点时把机房下所有的计数增加upgradeStep，再创建版本节点。
+// 多个进程同时升级或者升级中断后重新执行，只会多跳过一些号码，不会重复。
+// 升级前需要停止所有旧版本的进程
+func (p *ZK) upgradeRange(dataCenter uint8) error {
+	node := fmt.Sprintf(zkVersionTPL, zkRoot, dataCenter)
+	data, _, err := p.conn.Get(node)
+	switch err {
+	case nil:
+		if string(data) != zkRangeStart {
+			return fmt.Errorf("unknown zk range version %q", data)
+		}
+		return nil
+	case zk.ErrNoNode:
+	default:
+		return err
+	}
+	ctx := context.Background()
+	dbs, err := p.ListDBs(ctx, dataCenter)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	for _, db := range dbs {
+		tables, err := p.ListTables(ctx, dataCenter, db)
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			if err = p.bump(fmt.Sprintf(zkTPL, zkRoot, dataCenter, db, table), p.config.upgradeStep); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = p.conn.Create(node, []byte(zkRangeStart), 0, p.config.acl)
+	if err != nil && err != zk.ErrNodeExists {
+		return err
 	}
+	p.log.WithFields(logrus.Fields{"action": "upgrade", "dc": dataCenter, "step": p.config.upgradeStep}).Info()
 	return nil
 }
 
-func (p ZK) checkDB(dataCenter uint8, db string) bool {
-	biz := fmt.Sprintf("%s/%d/%s", zkRoot, dataCenter, db)
-	_, _, err := p.conn.Get(biz)
-	if err != nil {
-		fmt.Println("checkDB fail")
-		return false
+// bump 计数增加step，版本冲突时重试
+func (p *ZK) bump(biz string, step int64) error {
+	for {
+		data, stat, err := p.conn.Get(biz)
+		if err != nil {
+			return err
+		}
+		counter, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return err
+		}
+		_, err = p.conn.Set(biz, []byte(strconv.FormatInt(counter+step, 10)), stat.Version)
+		if err != zk.ErrBadVersion {
+			return err
+		}
 	}
-	return true
 }
 
-func (p ZK) BlockDB(dataCenter uint8, db string) bool {
-	if last, ok := p.blackDB[db]; ok {
-		if time.Since(last) < defaultFailSleep {
-			return false
+// CreateParentNodes 依次创建/rabbitid 和 /rabbitid/{dc}，已经存在的节点跳过。
+// 节点的数据由Payload指定，使用ACL指定的权限
+func (p *ZK) CreateParentNodes(dataCenter uint8) error {
+	nodes := []string{zkRoot, fmt.Sprintf("%s/%d", zkRoot, dataCenter)}
+	for i, node := range nodes {
+		var data []byte
+		if i < len(p.config.rootNodePayload) {
+			data = p.config.rootNodePayload[i]
+		}
+		_, err := p.conn.Create(node, data, 0, p.config.acl)
+		if err != nil && err != zk.ErrNodeExists {
+			p.log.WithFields(logrus.Fields{"action": "create", "node": node}).WithError(err).Error()
+			return err
 		}
 	}
-	return true
+	return nil
+}
+
+// BlockDB db不存在时由CircuitBreaker中间件跳过加载
+func (p *ZK) BlockDB(dataCenter uint8, db string) bool { return false }
+
+// CreateDB 创建/rabbitid/{dc}/{db}，父节点不存在时一起创建
+func (p *ZK) CreateDB(ctx context.Context, dataCenter uint8, db string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := p.CreateParentNodes(dataCenter); err != nil {
+		return err
+	}
+	biz := fmt.Sprintf("%s/%d/%s", zkRoot, dataCenter, db)
+	_, err := p.conn.Create(biz, nil, 0, p.config.acl)
+	if err != nil && err != zk.ErrNodeExists {
+		p.log.WithFields(logrus.Fields{"action": "create", "node": biz}).WithError(err).Error()
+		return err
+	}
+	return nil
+}
+
+// ListDBs 机房节点下的所有db
+func (p *ZK) ListDBs(ctx context.Context, dataCenter uint8) ([]string, error) {
+	dbs, err := p.children(ctx, fmt.Sprintf("%s/%d", zkRoot, dataCenter))
+	if err == ErrDBNotExists {
+		return nil, nil
+	}
+	return dbs, err
+}
+
+// ListTables db节点下的所有table
+func (p *ZK) ListTables(ctx context.Context, dataCenter uint8, db string) ([]string, error) {
+	return p.children(ctx, fmt.Sprintf("%s/%d/%s", zkRoot, dataCenter, db))
+}
+
+// GetCounter 已经分配的最大值
+func (p *ZK) GetCounter(ctx context.Context, dataCenter uint8, db, table string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	data, _, err := p.conn.Get(fmt.Sprintf(zkTPL, zkRoot, dataCenter, db, table))
+	switch err {
+	case nil:
+		return strconv.ParseInt(string(data), 10, 64)
+	case zk.ErrNoNode:
+		return 0, ErrTableNotExists
+	default:
+		return 0, err
+	}
+}
+
+// DeleteTable 删除table节点
+func (p *ZK) DeleteTable(ctx context.Context, dataCenter uint8, db, table string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := p.conn.Delete(fmt.Sprintf(zkTPL, zkRoot, dataCenter, db, table), -1)
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	return err
+}
+
+// children 子节点按名称排序，节点不存在返回ErrDBNotExists
+func (p *ZK) children(ctx context.Context, node string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	children, _, err := p.conn.Children(node)
+	switch err {
+	case nil:
+		sort.Strings(children)
+		return children, nil
+	case zk.ErrNoNode:
+		return nil, ErrDBNotExists
+	default:
+		return nil, err
+	}
 }
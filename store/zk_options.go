@@ -12,10 +12,12 @@ type zkConfig struct {
 	logger          *logrus.Entry
 	acl             []zk.ACL
 	credentials     []byte
+	user, pass      string
 	connectTimeout  time.Duration
 	sessionTimeout  time.Duration
 	rootNodePayload [][]byte
 	eventHandler    func(zk.Event)
+	upgradeStep     int64
 }
 
 // Option functions enable friendly APIs.
@@ -29,6 +31,19 @@ func Credentials(user, pass string) Option {
 			return ErrInvalidCredentials
 		}
 		c.credentials = []byte(user + ":" + pass)
+		c.user, c.pass = user, pass
+		return nil
+	}
+}
+
+// ACL returns an Option specifying the ACL of created znodes. When Credentials
+// is set and no ACL is given, znodes are only accessible by that user.
+func ACL(acl ...zk.ACL) Option {
+	return func(c *zkConfig) error {
+		if len(acl) == 0 {
+			return errors.New("invalid acl (at least one entry)")
+		}
+		c.acl = acl
 		return nil
 	}
 }
@@ -73,3 +88,16 @@ func EventHandler(handler func(zk.Event)) Option {
 		return nil
 	}
 }
+
+// UpgradeStep returns an Option specifying how much every counter is raised
+// when Init upgrades a data center whose Range used to return the end of the
+// range. It must be at least the largest size the previous version loaded.
+func UpgradeStep(step int64) Option {
+	return func(c *zkConfig) error {
+		if step <= 0 {
+			return errors.New("invalid upgrade step (must be positive)")
+		}
+		c.upgradeStep = step
+		return nil
+	}
+}
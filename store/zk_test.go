@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZK_HandleEvent(t *testing.T) {
+	var events []zk.Event
+	p := &ZK{active: 1, log: logrus.NewEntry(logrus.New())}
+	p.config.eventHandler = func(e zk.Event) { events = append(events, e) }
+
+	p.handleEvent(zk.Event{Type: zk.EventSession, State: zk.StateDisconnected})
+	assert.False(t, p.isActive())
+	assert.EqualError(t, p.Ping(context.TODO()), ErrZKInactive.Error())
+	_, err := p.Range(context.TODO(), testDC, testDB, testTable, testSize)
+	assert.EqualError(t, err, ErrZKInactive.Error())
+
+	// 节点事件不影响会话状态
+	p.handleEvent(zk.Event{Type: zk.EventNodeDataChanged, State: zk.StateHasSession})
+	assert.False(t, p.isActive())
+
+	p.handleEvent(zk.Event{Type: zk.EventSession, State: zk.StateHasSession})
+	assert.True(t, p.isActive())
+	p.handleEvent(zk.Event{Type: zk.EventSession, State: zk.StateExpired})
+	assert.False(t, p.isActive())
+	assert.Len(t, events, 4)
+}
+
+func TestZK_Options(t *testing.T) {
+	var c zkConfig
+	assert.EqualError(t, Credentials("", "pass")(&c), ErrInvalidCredentials.Error())
+	assert.NoError(t, Credentials("user", "pass")(&c))
+	assert.Equal(t, c.credentials, []byte("user:pass"))
+
+	assert.Error(t, ACL()(&c))
+	assert.NoError(t, ACL(zk.WorldACL(zk.PermRead)...)(&c))
+	assert.Equal(t, c.acl, zk.WorldACL(zk.PermRead))
+
+	assert.Error(t, UpgradeStep(0)(&c))
+	assert.NoError(t, UpgradeStep(10)(&c))
+	assert.Equal(t, c.upgradeStep, int64(10))
+}
+
+func TestZK_UpgradeRange(t *testing.T) {
+	const dc uint8 = 9
+	p, err := dialZK("127.0.0.1:2181", logrus.NewEntry(logrus.New()), UpgradeStep(testSize*10))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer p.Stop()
+
+	// 模拟旧版本的数据：有计数，没有版本节点
+	assert.NoError(t, p.CreateDB(context.TODO(), dc, testDB))
+	biz := fmt.Sprintf(zkTPL, zkRoot, dc, testDB, testTable)
+	_ = p.conn.Delete(biz, -1)
+	_ = p.conn.Delete(fmt.Sprintf(zkVersionTPL, zkRoot, dc), -1)
+	_, err = p.conn.Create(biz, []byte("200"), 0, DefaultACL)
+	assert.NoError(t, err)
+
+	// 只升级一次
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, p.Init(dc))
+		n, err := p.GetCounter(context.TODO(), dc, testDB, testTable)
+		assert.NoError(t, err)
+		assert.Equal(t, n, int64(200+testSize*10))
+	}
+}